@@ -84,3 +84,26 @@ func ExampleLLRBSet() {
 	// 1
 	// 3
 }
+
+func ExampleLLRBMultiSet() {
+	s := llrb.NewMultiSet[string]()
+
+	s.Add("apple")
+	s.Add("banana")
+	s.Add("apple")
+
+	fmt.Println(s.Len())
+	fmt.Println(s.Count("apple"))
+
+	s.Remove("apple")
+
+	s.Range(func(item string, count int) bool {
+		fmt.Println(item, count)
+		return true
+	})
+	// Output:
+	// 3
+	// 2
+	// apple 1
+	// banana 1
+}
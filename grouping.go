@@ -0,0 +1,61 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import "cmp"
+
+// LLRBGroupingMap represents an ordered map that groups all the values added
+// under the same key into a slice.
+type LLRBGroupingMap[K cmp.Ordered, V any] struct {
+	m *LLRBMap[K, []V]
+}
+
+// NewGroupingMap creates a new LLRBGroupingMap.
+func NewGroupingMap[K cmp.Ordered, V any]() *LLRBGroupingMap[K, V] {
+	return &LLRBGroupingMap[K, V]{
+		m: NewMap[K, []V](),
+	}
+}
+
+// Add appends the value to the group of the specified key, creating the
+// group if it doesn't exist yet.
+func (g *LLRBGroupingMap[K, V]) Add(key K, value V) {
+	ent, ok := g.m.tr.Get(&entry[K, []V]{key: key})
+	if ok {
+		ent.value = append(ent.value, value)
+		return
+	}
+	g.m.Set(key, []V{value})
+}
+
+// Get retrieves the values added under the specified key, in the order they
+// were added. It returns the values and a boolean indicating if the key
+// exists in the map.
+func (g *LLRBGroupingMap[K, V]) Get(key K) ([]V, bool) {
+	return g.m.Get(key)
+}
+
+// Delete removes the specified key and all its values from the map.
+// It returns the values and a boolean indicating if the key existed.
+func (g *LLRBGroupingMap[K, V]) Delete(key K) ([]V, bool) {
+	return g.m.Delete(key)
+}
+
+// Range iterates over the groups in the map in ascending order of the keys.
+// The provided callback function is called for each key and its values.
+// Iteration stops if the callback function returns false.
+func (g *LLRBGroupingMap[K, V]) Range(iter func(key K, values []V) bool) {
+	g.m.Range(iter)
+}
+
+// Len returns the number of keys in the map.
+func (g *LLRBGroupingMap[K, V]) Len() int {
+	return g.m.Len()
+}
+
+// Clear removes all keys and values from the map, resulting in an empty map.
+func (g *LLRBGroupingMap[K, V]) Clear() {
+	g.m.Clear()
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLRBGroupingMap(t *testing.T) {
+	assert := assert.New(t)
+
+	g := NewGroupingMap[string, int]()
+	g.Add("b", 1)
+	g.Add("a", 2)
+	g.Add("b", 3)
+	g.Add("c", 4)
+	g.Add("a", 5)
+	g.Add("b", 6)
+	assert.Equal(3, g.Len())
+
+	v, ok := g.Get("b")
+	assert.Equal([]int{1, 3, 6}, v)
+	assert.True(ok)
+	v, ok = g.Get("d")
+	assert.Nil(v)
+	assert.False(ok)
+
+	var keys []string
+	var groups [][]int
+	g.Range(func(key string, values []int) bool {
+		keys = append(keys, key)
+		groups = append(groups, values)
+		return true
+	})
+	assert.Equal([]string{"a", "b", "c"}, keys)
+	assert.Equal([][]int{{2, 5}, {1, 3, 6}, {4}}, groups)
+
+	v, ok = g.Delete("a")
+	assert.Equal([]int{2, 5}, v)
+	assert.True(ok)
+	assert.Equal(2, g.Len())
+
+	keys = keys[:0]
+	g.Range(func(key string, _ []int) bool {
+		keys = append(keys, key)
+		return false
+	})
+	assert.Equal([]string{"b"}, keys)
+
+	g.Clear()
+	assert.Equal(0, g.Len())
+}
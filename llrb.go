@@ -10,7 +10,20 @@
 // for operations, but is designed to be easier to implement.
 package llrb
 
-import "cmp"
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"iter"
+	"maps"
+	"math"
+	"math/bits"
+	"math/rand"
+	"slices"
+)
 
 const (
 	_red   = true
@@ -21,38 +34,234 @@ const (
 // It should return a negative value if a < b, 0 if a == b, and a positive value if a > b.
 type CompareFunc[T any] func(a, b T) int
 
+// CompareFuncE is like CompareFunc, but the comparison may fail with an
+// error, e.g. when the values have to be parsed before they can be compared.
+type CompareFuncE[T any] func(a, b T) (int, error)
+
+type number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
 // IterFunc is a function type that takes a value of type T and returns a boolean.
 // It should return true to continue iteration, or false to stop iteration.
 type IterFunc[T any] func(a T) bool
 
+// ItemCount pairs an item with a count.
+type ItemCount[T any] struct {
+	Item  T
+	Count int
+}
+
 // LLRBTree is a Left-Leaning Red-Black (LLRB) implementation of 2-3 trees.
 type LLRBTree[T any] struct {
 	root    *node[T]
 	compare CompareFunc[T]
 	len     int
+	opts    options
+	seq     uint64
+	bulk    []T
+	inBulk  bool
+
+	rotations uint64
+
+	// order maps every node to its insertion sequence number, when
+	// insertion order is tracked. It is kept outside the nodes so that
+	// other trees pay nothing for it. After a Snapshot, the map is shared
+	// until either tree next writes to it, see ownOrder.
+	order       map[*node[T]]uint64
+	orderShared bool
+
+	// token marks the nodes owned by the tree, which it may modify in
+	// place. Nodes with another token may be shared with a snapshot and
+	// are copied before being modified.
+	token *cowToken
+}
+
+// cowToken identifies the owner of copy-on-write nodes. It is not zero-sized,
+// so that every allocated token has a distinct address.
+type cowToken struct {
+	_ byte
 }
 
 type node[T any] struct {
 	item        T
 	left, right *node[T]
-	color       bool
+	aug         uint64 // see size, height and color
+	owner       *cowToken
 }
 
 // New creates a new LLRB-Tree with the given compare function.
-func New[T any](compare CompareFunc[T]) *LLRBTree[T] {
+func New[T any](compare CompareFunc[T], opts ...Option) *LLRBTree[T] {
+	if compare == nil {
+		panic("nil compare")
+	}
+	return newTree(compare, opts)
+}
+
+// NewOrdered creates a new LLRB-Tree for ordered types.
+func NewOrdered[T cmp.Ordered](opts ...Option) *LLRBTree[T] {
+	return newTree(cmp.Compare[T], opts)
+}
+
+// NewLLRBTreeE creates a new LLRB-Tree with the given fallible compare
+// function. Errors returned by it are reported by InsertE and GetE, which
+// leave the tree unchanged when the comparison fails. All the other methods
+// panic with the error, and may leave the tree in an inconsistent state if
+// they were modifying it.
+func NewLLRBTreeE[T any](compare CompareFuncE[T], opts ...Option) *LLRBTree[T] {
 	if compare == nil {
 		panic("nil compare")
 	}
-	return &LLRBTree[T]{
+	return newTree(func(a, b T) int {
+		cmp, err := compare(a, b)
+		if err != nil {
+			panic(&compareError{err: err})
+		}
+		return cmp
+	}, opts)
+}
+
+// compareError carries an error of a CompareFuncE through a panic.
+type compareError struct {
+	err error
+}
+
+func (e *compareError) Error() string {
+	return "llrb: compare: " + e.err.Error()
+}
+
+func (e *compareError) Unwrap() error {
+	return e.err
+}
+
+// recoverCompare recovers from a panic caused by a failed CompareFuncE and
+// stores its error in err. Any other panic is propagated.
+func recoverCompare(err *error) {
+	if r := recover(); r != nil {
+		ce, ok := r.(*compareError)
+		if !ok {
+			panic(r)
+		}
+		*err = ce.err
+	}
+}
+
+// NewFloatTree creates a new LLRB-Tree for floating-point types.
+//
+// Unlike NewOrdered, NaN values sort after every other value, including +Inf,
+// and all NaN values compare equal to each other, so the tree holds at most
+// one NaN. -0 and +0 compare equal.
+func NewFloatTree[T ~float32 | ~float64](opts ...Option) *LLRBTree[T] {
+	return newTree(compareFloat[T], opts)
+}
+
+// NewFromUnsorted creates a new LLRB-Tree with the given compare function
+// holding the given items, which may be in any order and contain duplicates;
+// of several equal items, the last one is kept. The items are sorted and the
+// tree is built bottom-up in O(n log n), which is considerably faster than
+// inserting them one by one. The input slice is not modified.
+func NewFromUnsorted[T any](compare CompareFunc[T], items []T, opts ...Option) *LLRBTree[T] {
+	t := New(compare, opts...)
+	t.MergeSortedSlice(sortUnique(slices.Clone(items), compare))
+	return t
+}
+
+// FromLevelOrder creates a new LLRB-Tree with the given compare function from
+// items in the breadth-first order returned by LevelOrder, reproducing the
+// shape of the original tree. Colors are not part of the input and are
+// recomputed from the shape. If the items don't describe a valid LLRB shape,
+// the tree is rebuilt as a balanced tree holding the same items instead.
+func FromLevelOrder[T any](compare CompareFunc[T], items []T, opts ...Option) *LLRBTree[T] {
+	t := New(compare, opts...)
+	for _, item := range items {
+		t.place(item)
+	}
+	if _, ok := t.recolor(t.root); !ok {
+		t.root = t.buildNodes(t.nodes())
+	}
+	if t.root != nil {
+		t.root.setColor(_black)
+	}
+	return t
+}
+
+// place inserts the item as a leaf without rebalancing the tree.
+func (t *LLRBTree[T]) place(item T) {
+	p := &t.root
+	for *p != nil {
+		*p = t.mutable(*p)
+		cmp := t.compare(item, (*p).item)
+		if cmp == 0 {
+			(*p).item = item
+			return
+		} else if cmp < 0 {
+			p = &(*p).left
+		} else {
+			p = &(*p).right
+		}
+	}
+	*p = t.newNode(item)
+	t.len++
+}
+
+// recolor assigns the only LLRB coloring that fits the shape of the subtree
+// rooted at h, and updates its sizes. It returns the black height of the
+// subtree with h counted as black, and false if no valid coloring exists.
+func (t *LLRBTree[T]) recolor(h *node[T]) (int, bool) {
+	if h == nil {
+		return 0, true
+	}
+	lbh, lok := t.recolor(h.left)
+	rbh, rok := t.recolor(h.right)
+	if !lok || !rok {
+		return 0, false
+	}
+	if h.right != nil {
+		h.right.setColor(_black)
+	}
+	if h.left != nil {
+		switch {
+		case lbh == rbh:
+			h.left.setColor(_black)
+		case lbh == rbh+1 && !isRed(h.left.left):
+			h.left.setColor(_red)
+		default:
+			return 0, false
+		}
+	} else if rbh != 0 {
+		return 0, false
+	}
+	t.update(h)
+	return rbh + 1, true
+}
+
+func newTree[T any](compare CompareFunc[T], opts []Option) *LLRBTree[T] {
+	t := &LLRBTree[T]{
 		compare: compare,
 	}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+	return t
 }
 
-// NewOrdered creates a new LLRB-Tree for ordered types.
-func NewOrdered[T cmp.Ordered]() *LLRBTree[T] {
-	return &LLRBTree[T]{
-		compare: cmp.Compare[T],
+func compareFloat[T ~float32 | ~float64](a, b T) int {
+	aNaN, bNaN := a != a, b != b
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return 1
+	case bNaN:
+		return -1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
 	}
+	return 0
 }
 
 // ReplaceOrInsert adds the given item to the tree. If an item in the tree
@@ -61,14 +270,162 @@ func NewOrdered[T cmp.Ordered]() *LLRBTree[T] {
 //
 // Note: nil cannot be added to the tree (undefined behavior).
 func (t *LLRBTree[T]) ReplaceOrInsert(item T) (prev T, exist bool) {
-	t.root, prev, exist = t.insert(t.root, item)
-	t.root.color = _black
+	if t.inBulk {
+		t.bulk = append(t.bulk, item)
+		return zero[T](), false
+	}
+	t.root, prev, exist = t.insert(t.root, item, nil)
+	t.root.setColor(_black)
 	if !exist {
 		t.len++
 	}
 	return prev, exist
 }
 
+// InsertOrMerge adds the given item to the tree, or, if an item in the tree
+// already equals it, replaces that item with merge(existing, item), in a
+// single descent. It returns the item stored in the tree. The merged item
+// must compare equal to the existing one, otherwise the behavior is
+// undefined.
+//
+// It panics if called between BeginBulk and EndBulk, since the buffered
+// items cannot be merged with.
+func (t *LLRBTree[T]) InsertOrMerge(item T, merge func(existing, incoming T) T) (result T) {
+	if t.inBulk {
+		panic("llrb: InsertOrMerge during bulk insert")
+	}
+	result = item
+	var exist bool
+	t.root, _, exist = t.insert(t.root, item, func(existing, incoming T) T {
+		result = merge(existing, incoming)
+		return result
+	})
+	t.root.setColor(_black)
+	if !exist {
+		t.len++
+	}
+	return result
+}
+
+// MergeSortedSlice adds all the given items to the tree, replacing the items
+// that are already present, and returns the number of items that were newly
+// added.
+//
+// The items must be sorted in ascending order and contain no duplicates
+// according to the compare function of the tree, otherwise the behavior is
+// undefined. Large batches are merged with the existing items in a single
+// linear pass and the tree is rebuilt in O(n + m), which needs far fewer
+// comparisons than inserting the items one by one.
+//
+// Between BeginBulk and EndBulk, the items are only buffered, as with
+// ReplaceOrInsert, and 0 is returned; EndBulk counts them instead.
+func (t *LLRBTree[T]) MergeSortedSlice(items []T) int {
+	if t.inBulk {
+		t.bulk = append(t.bulk, items...)
+		return 0
+	}
+	if len(items)*bits.Len(uint(t.len)) < t.len {
+		added := 0
+		for _, item := range items {
+			if _, exist := t.ReplaceOrInsert(item); !exist {
+				added++
+			}
+		}
+		return added
+	}
+
+	old := t.nodes()
+	for i, h := range old {
+		old[i] = t.mutable(h)
+	}
+	merged := make([]*node[T], 0, len(old)+len(items))
+	i, j := 0, 0
+	for i < len(old) && j < len(items) {
+		cmp := t.compare(old[i].item, items[j])
+		if cmp < 0 {
+			merged = append(merged, old[i])
+			i++
+		} else if cmp > 0 {
+			merged = append(merged, t.newNode(items[j]))
+			j++
+		} else {
+			old[i].item = items[j]
+			merged = append(merged, old[i])
+			i++
+			j++
+		}
+	}
+	merged = append(merged, old[i:]...)
+	for ; j < len(items); j++ {
+		merged = append(merged, t.newNode(items[j]))
+	}
+
+	added := len(merged) - len(old)
+	t.root = t.buildNodes(merged)
+	t.len = len(merged)
+	return added
+}
+
+// BeginBulk starts a bulk window. Until EndBulk is called, ReplaceOrInsert
+// only buffers the items and returns (zeroValue, false), MergeSortedSlice
+// only buffers the items and returns 0, and all the other methods, including
+// lookups and Len, only see the items that were in the tree before the window
+// started. Deletions made during the window don't affect the buffered items.
+func (t *LLRBTree[T]) BeginBulk() {
+	t.inBulk = true
+}
+
+// EndBulk ends the bulk window started by BeginBulk, merging the buffered
+// items into the tree and rebuilding it at once, which is much faster than
+// inserting a large batch one item at a time. If an item was buffered more
+// than once, the last one wins. It returns the number of newly added items.
+func (t *LLRBTree[T]) EndBulk() int {
+	if !t.inBulk {
+		return 0
+	}
+	items := t.bulk
+	t.bulk, t.inBulk = nil, false
+	return t.MergeSortedSlice(sortUnique(items, t.compare))
+}
+
+// sortUnique sorts the items in place and removes the duplicates, keeping
+// the last of every run of equal items, as repeated ReplaceOrInsert calls
+// would. It returns the deduplicated prefix of items.
+func sortUnique[T any](items []T, compare CompareFunc[T]) []T {
+	slices.SortStableFunc(items, compare)
+	uniq := items[:0]
+	for i, item := range items {
+		if i+1 < len(items) && compare(item, items[i+1]) == 0 {
+			continue
+		}
+		uniq = append(uniq, item)
+	}
+	return uniq
+}
+
+// InsertE is like ReplaceOrInsert, but returns the error of a failed
+// comparison for a tree created with NewLLRBTreeE. The tree is unchanged if
+// an error is returned.
+//
+// It returns an error if called between BeginBulk and EndBulk, since the
+// buffered items are only compared by EndBulk, which cannot report errors.
+func (t *LLRBTree[T]) InsertE(item T) (prev T, exist bool, err error) {
+	if t.inBulk {
+		return zero[T](), false, errors.New("llrb: InsertE during bulk insert")
+	}
+	defer recoverCompare(&err)
+	prev, exist = t.ReplaceOrInsert(item)
+	return prev, exist, nil
+}
+
+// GetE is like Get, but returns the error of a failed comparison for a tree
+// created with NewLLRBTreeE.
+func (t *LLRBTree[T]) GetE(item T) (found T, ok bool, err error) {
+	defer recoverCompare(&err)
+	found, ok = t.Get(item)
+	return found, ok, nil
+}
+
 // Get looks for the key item in the tree, returning it.  It returns
 // (zeroValue, false) if unable to find that item.
 func (t *LLRBTree[T]) Get(item T) (T, bool) {
@@ -87,23 +444,71 @@ func (t *LLRBTree[T]) Get(item T) (T, bool) {
 	return zero[T](), false
 }
 
+// WouldChange returns true if ReplaceOrInsert of the given item would add a
+// new item to the tree, i.e. no equal item exists yet. The tree is not
+// modified.
+func (t *LLRBTree[T]) WouldChange(item T) bool {
+	return !t.Has(item)
+}
+
+// DepthOf returns the number of edges between the root and the node holding
+// an item equal to the given one, which is the root itself at depth 0. It
+// returns (0, false) if no such item exists.
+func (t *LLRBTree[T]) DepthOf(item T) (int, bool) {
+	depth := 0
+	x := t.root
+	for x != nil {
+		cmp := t.compare(item, x.item)
+		if cmp == 0 {
+			return depth, true
+		} else if cmp < 0 {
+			x = x.left
+		} else {
+			x = x.right
+		}
+		depth++
+	}
+	return 0, false
+}
+
 // Has returns true if the given key is in the tree.
 func (t *LLRBTree[T]) Has(item T) bool {
 	_, ok := t.Get(item)
 	return ok
 }
 
+// Min returns the smallest item in the tree without removing it.
+// If no such item exists, it returns (zeroValue, false).
+func (t *LLRBTree[T]) Min() (T, bool) {
+	if h := t.minNode(); h != nil {
+		return h.item, true
+	}
+	return zero[T](), false
+}
+
+// Max returns the largest item in the tree without removing it.
+// If no such item exists, it returns (zeroValue, false).
+func (t *LLRBTree[T]) Max() (T, bool) {
+	if h := t.maxNode(); h != nil {
+		return h.item, true
+	}
+	return zero[T](), false
+}
+
 // DeleteMin removes the smallest item in the tree and returns it.
 // If no such item exists, it returns (nil, false).
-func (t *LLRBTree[T]) DeleteMin() (deleted T, ok bool) {
-	t.root, deleted, ok = t.deleteMin(t.root)
+func (t *LLRBTree[T]) DeleteMin() (T, bool) {
+	var deleted *node[T]
+	t.root, deleted = t.deleteMin(t.root)
 	if t.root != nil {
-		t.root.color = _black
+		t.root.setColor(_black)
 	}
-	if ok {
-		t.len--
+	if deleted == nil {
+		return zero[T](), false
 	}
-	return deleted, ok
+	t.forget(deleted)
+	t.len--
+	return deleted.item, true
 }
 
 // DeleteMax removes the largest item in the tree and returns it.
@@ -111,7 +516,7 @@ func (t *LLRBTree[T]) DeleteMin() (deleted T, ok bool) {
 func (t *LLRBTree[T]) DeleteMax() (deleted T, ok bool) {
 	t.root, deleted, ok = t.deleteMax(t.root)
 	if t.root != nil {
-		t.root.color = _black
+		t.root.setColor(_black)
 	}
 	if ok {
 		t.len--
@@ -119,12 +524,220 @@ func (t *LLRBTree[T]) DeleteMax() (deleted T, ok bool) {
 	return deleted, ok
 }
 
+// CeilWithIndex returns the smallest item in the tree that is greater than or
+// equal to the given item, together with its zero-based index in ascending
+// order. It returns (zeroValue, -1, false) if no such item exists.
+func (t *LLRBTree[T]) CeilWithIndex(item T) (T, int, bool) {
+	var (
+		ceil  T
+		index = -1
+		rank  int
+	)
+	x := t.root
+	for x != nil {
+		cmp := t.compare(item, x.item)
+		if cmp == 0 {
+			return x.item, rank + size(x.left), true
+		} else if cmp < 0 {
+			ceil, index = x.item, rank+size(x.left)
+			x = x.left
+		} else {
+			rank += size(x.left) + 1
+			x = x.right
+		}
+	}
+	return ceil, index, index >= 0
+}
+
+// FloorWithIndex returns the largest item in the tree that is less than or
+// equal to the given item, together with its zero-based index in ascending
+// order. It returns (zeroValue, -1, false) if no such item exists.
+func (t *LLRBTree[T]) FloorWithIndex(item T) (T, int, bool) {
+	var (
+		floor T
+		index = -1
+		rank  int
+	)
+	x := t.root
+	for x != nil {
+		cmp := t.compare(item, x.item)
+		if cmp == 0 {
+			return x.item, rank + size(x.left), true
+		} else if cmp < 0 {
+			x = x.left
+		} else {
+			floor, index = x.item, rank+size(x.left)
+			rank += size(x.left) + 1
+			x = x.right
+		}
+	}
+	return floor, index, index >= 0
+}
+
+// Rank returns the number of items in the tree strictly less than the given
+// item, which need not be in the tree. Together with Select, it answers
+// order-statistic queries in O(log n) using the subtree sizes kept in every
+// node. It is the same as RankOf.
+func (t *LLRBTree[T]) Rank(item T) int {
+	return t.RankOf(item)
+}
+
+// Select returns the k-th smallest item in the tree, counting from 0, or
+// (zeroValue, false) if k is out of range. It runs in O(log n); Select(Len()/2)
+// is the median.
+func (t *LLRBTree[T]) Select(k int) (T, bool) {
+	return t.itemAt(k)
+}
+
+// CountRange returns the number of items within the range [greaterOrEqual,
+// lessThan), computed in O(log n) from the ranks of the bounds rather than by
+// visiting the items. It returns 0 if the range is empty.
+func (t *LLRBTree[T]) CountRange(greaterOrEqual, lessThan T) int {
+	return max(t.CountLess(lessThan)-t.CountLess(greaterOrEqual), 0)
+}
+
+// CountLess returns the number of items less than pivot in O(log n).
+func (t *LLRBTree[T]) CountLess(pivot T) int {
+	return t.RankOf(pivot)
+}
+
+// CountGreaterOrEqual returns the number of items greater than or equal to
+// pivot in O(log n).
+func (t *LLRBTree[T]) CountGreaterOrEqual(pivot T) int {
+	return t.len - t.RankOf(pivot)
+}
+
+// RankOf returns the number of items in the tree strictly less than the
+// given item, whether or not an equal item is present. For an absent item it
+// is the index at which the item would be inserted. It runs in O(log n).
+func (t *LLRBTree[T]) RankOf(item T) int {
+	rank, _ := t.rank(item)
+	return rank
+}
+
+// PercentileOf returns the percentile, in the range [0, 100), occupied by an
+// item equal to the given one, that is the number of smaller items divided by
+// Len and scaled to 100. It returns (0, false) if no such item exists.
+func (t *LLRBTree[T]) PercentileOf(item T) (float64, bool) {
+	rank, ok := t.rank(item)
+	if !ok {
+		return 0, false
+	}
+	return float64(rank) / float64(t.len) * 100, true
+}
+
+// FloorCeil returns both the largest item less than or equal to the given
+// item and the smallest item greater than or equal to it, found in a single
+// descent. On an exact match both are the matching item. The flags report
+// whether the floor and the ceil exist.
+func (t *LLRBTree[T]) FloorCeil(item T) (floor, ceil T, hasFloor, hasCeil bool) {
+	x := t.root
+	for x != nil {
+		cmp := t.compare(item, x.item)
+		if cmp == 0 {
+			return x.item, x.item, true, true
+		} else if cmp < 0 {
+			ceil, hasCeil = x.item, true
+			x = x.left
+		} else {
+			floor, hasFloor = x.item, true
+			x = x.right
+		}
+	}
+	return floor, ceil, hasFloor, hasCeil
+}
+
+// Predecessor returns the largest item in the tree strictly less than the
+// given item, which need not be in the tree. It returns (zeroValue, false)
+// if no such item exists.
+func (t *LLRBTree[T]) Predecessor(item T) (T, bool) {
+	var (
+		pred T
+		ok   bool
+	)
+	x := t.root
+	for x != nil {
+		if t.compare(item, x.item) <= 0 {
+			x = x.left
+		} else {
+			pred, ok = x.item, true
+			x = x.right
+		}
+	}
+	return pred, ok
+}
+
+// Successor returns the smallest item in the tree strictly greater than the
+// given item, which need not be in the tree. It returns (zeroValue, false)
+// if no such item exists.
+func (t *LLRBTree[T]) Successor(item T) (T, bool) {
+	var (
+		succ T
+		ok   bool
+	)
+	x := t.root
+	for x != nil {
+		if t.compare(item, x.item) >= 0 {
+			x = x.right
+		} else {
+			succ, ok = x.item, true
+			x = x.left
+		}
+	}
+	return succ, ok
+}
+
+// ClampFunc returns the stored item closest to the given item according to
+// dist, which must return the non-negative distance between two items. The
+// item itself is returned if it is stored in the tree; otherwise the closer
+// of its floor and ceil is returned, and ties go to the ceil. It returns
+// (zeroValue, false) if the tree is empty.
+func (t *LLRBTree[T]) ClampFunc(item T, dist func(a, b T) int64) (T, bool) {
+	floor, _, hasFloor := t.FloorWithIndex(item)
+	ceil, _, hasCeil := t.CeilWithIndex(item)
+	switch {
+	case hasFloor && hasCeil:
+		if dist(floor, item) < dist(item, ceil) {
+			return floor, true
+		}
+		return ceil, true
+	case hasFloor:
+		return floor, true
+	case hasCeil:
+		return ceil, true
+	}
+	return zero[T](), false
+}
+
+// Clamp returns the item stored in the tree closest to the given numeric
+// item: the item itself if stored, otherwise the closer of its floor and
+// ceil, with ties going to the ceil. The distances are computed without
+// overflowing T. It returns (zeroValue, false) if the tree is empty.
+func Clamp[T number](t *LLRBTree[T], item T) (T, bool) {
+	floor, _, hasFloor := t.FloorWithIndex(item)
+	ceil, _, hasCeil := t.CeilWithIndex(item)
+	switch {
+	case hasFloor && hasCeil:
+		fu, ff := distance(floor, item)
+		cu, cf := distance(item, ceil)
+		if fu < cu || ff < cf {
+			return floor, true
+		}
+		return ceil, true
+	case hasFloor:
+		return floor, true
+	case hasCeil:
+		return ceil, true
+	}
+	return zero[T](), false
+}
+
 // Delete removes an item equal to the passed-in item from the tree, returning
 // it. If no such item exists, it returns (nil, false).
 func (t *LLRBTree[T]) Delete(item T) (deleted T, ok bool) {
 	t.root, deleted, ok = t.delete(t.root, item)
 	if t.root != nil {
-		t.root.color = _black
+		t.root.setColor(_black)
 	}
 	if ok {
 		t.len--
@@ -132,10 +745,145 @@ func (t *LLRBTree[T]) Delete(item T) (deleted T, ok bool) {
 	return deleted, ok
 }
 
+// ReplaceFunc replaces every item for which pred returns true with fn(item).
+// The matching items are collected first, then all removed, and the new
+// items are inserted afterwards, so fn may move an item to a different
+// position in the tree. A new item replaces any equal item already in the
+// tree, as with ReplaceOrInsert.
+func (t *LLRBTree[T]) ReplaceFunc(pred func(T) bool, fn func(T) T) {
+	var matches []T
+	t.Ascend(func(item T) bool {
+		if pred(item) {
+			matches = append(matches, item)
+		}
+		return true
+	})
+	for _, item := range matches {
+		t.Delete(item)
+	}
+	for _, item := range matches {
+		t.ReplaceOrInsert(fn(item))
+	}
+}
+
 // Clear removes all items from the LLRB-Tree.
 func (t *LLRBTree[T]) Clear() {
 	t.root = nil
 	t.len = 0
+	t.order, t.orderShared = nil, false
+}
+
+// Clone returns an independent copy of the tree, with the same compare
+// function and options, so that modifying one does not affect the other. The
+// nodes are copied one by one, keeping the exact shape of the tree, and the
+// items themselves are copied by assignment. It runs in O(n).
+func (t *LLRBTree[T]) Clone() *LLRBTree[T] {
+	c := *t
+	c.token = nil
+	c.order, c.orderShared = nil, false
+	var clone func(h *node[T]) *node[T]
+	clone = func(h *node[T]) *node[T] {
+		if h == nil {
+			return nil
+		}
+		n := *h
+		n.left, n.right = clone(h.left), clone(h.right)
+		n.owner = nil
+		if t.opts.insertionOrder {
+			c.setStamp(&n, t.order[h])
+		}
+		return &n
+	}
+	c.root = clone(t.root)
+	c.bulk = slices.Clone(t.bulk)
+	return &c
+}
+
+// Snapshot returns a copy of the tree in O(1) that shares all its nodes with
+// the original. Both trees stay fully usable: the shared nodes are never
+// modified in place again, and every later change to either tree copies the
+// nodes along the modified path first, so neither tree ever observes the
+// changes made to the other. The items themselves are copied by assignment.
+//
+// Snapshots suit read-heavy workloads with occasional writes, where a
+// consistent view must be kept without paying for a full Clone. For a tree
+// created WithInsertionOrder, the first change to either tree after a Snapshot
+// also copies the insertion order of all the items, in O(n).
+//
+// To tell shared nodes apart, every node records the tree that owns it, which
+// costs one pointer per node in every tree, whether Snapshot is used or not.
+// The node color is packed with the subtree size to make room for it.
+func (t *LLRBTree[T]) Snapshot() *LLRBTree[T] {
+	s := *t
+	s.bulk = slices.Clone(t.bulk)
+	t.token, s.token = new(cowToken), new(cowToken)
+	if t.order != nil {
+		t.orderShared, s.orderShared = true, true
+	}
+	return &s
+}
+
+// RotationCount returns the total number of rotations performed to rebalance
+// the tree since it was created. It is always 0 unless the tree was created
+// with WithRotationCounter.
+func (t *LLRBTree[T]) RotationCount() uint64 {
+	return t.rotations
+}
+
+// Height returns the number of levels of the tree, that is the number of
+// nodes on its longest root-to-leaf path, or 0 for an empty tree. It runs in
+// O(1) if the tree was created with WithHeightTracking, and walks the whole
+// tree otherwise.
+func (t *LLRBTree[T]) Height() int {
+	if t.opts.trackHeight {
+		return height(t.root)
+	}
+	var walk func(h *node[T]) int
+	walk = func(h *node[T]) int {
+		if h == nil {
+			return 0
+		}
+		return 1 + max(walk(h.left), walk(h.right))
+	}
+	return walk(t.root)
+}
+
+// MinDepth returns the number of nodes on the shortest path from the root to
+// a leaf, or 0 for an empty tree. A red-black tree guarantees that Height is
+// at most twice MinDepth, so their ratio is a quick indicator of balance. It
+// runs in O(n).
+func (t *LLRBTree[T]) MinDepth() int {
+	var walk func(h *node[T]) int
+	walk = func(h *node[T]) int {
+		switch {
+		case h == nil:
+			return 0
+		case h.left == nil:
+			return 1 + walk(h.right)
+		case h.right == nil:
+			return 1 + walk(h.left)
+		}
+		return 1 + min(walk(h.left), walk(h.right))
+	}
+	return walk(t.root)
+}
+
+// Root returns the item stored at the root node of the tree, or
+// (zeroValue, false) if the tree is empty. It is an inspection aid for
+// debugging and reasoning about balance; which item sits at the root has no
+// meaning for the ordered contents of the tree.
+func (t *LLRBTree[T]) Root() (T, bool) {
+	if t.root == nil {
+		return zero[T](), false
+	}
+	return t.root.item, true
+}
+
+// Swap exchanges the contents of the two trees in O(1). The compare functions
+// and options are exchanged along with the items, so each tree stays
+// consistent and the two trees need not share a compare function.
+func (t *LLRBTree[T]) Swap(other *LLRBTree[T]) {
+	*t, *other = *other, *t
 }
 
 // Len returns the number of items currently in the tree.
@@ -143,6 +891,44 @@ func (t *LLRBTree[T]) Len() int {
 	return t.len
 }
 
+// Validate checks the structural invariants of the tree: items are in
+// ascending order, red links lean left, no node has two red links, every
+// path from the root to a leaf has the same number of black links, and the
+// subtree sizes and length agree with the actual number of items. It is
+// intended for tests and debugging, and runs in O(n).
+func (t *LLRBTree[T]) Validate() error {
+	if isRed(t.root) {
+		return errors.New("llrb: root is red")
+	}
+	if _, err := t.validate(t.root, nullItem[T]{}, nullItem[T]{}); err != nil {
+		return err
+	}
+	if n := size(t.root); n != t.len {
+		return fmt.Errorf("llrb: len is %d, but the tree holds %d items", t.len, n)
+	}
+	return nil
+}
+
+// RecomputeSizes re-derives the subtree size of every node, its height if
+// tracked, and the length of the tree, with a post-order walk. It is a
+// maintenance utility to repair the order-statistic data after a bug or a
+// manual edit left it stale; normal operations keep the sizes up to date.
+func (t *LLRBTree[T]) RecomputeSizes() {
+	var walk func(h *node[T]) *node[T]
+	walk = func(h *node[T]) *node[T] {
+		if h == nil {
+			return nil
+		}
+		h = t.mutable(h)
+		h.left = walk(h.left)
+		h.right = walk(h.right)
+		t.update(h)
+		return h
+	}
+	t.root = walk(t.root)
+	t.len = size(t.root)
+}
+
 // AscendRange calls the iterator for every value in the tree within the range
 // [greaterOrEqual, lessThan), until the iterator returns false.
 func (t *LLRBTree[T]) AscendRange(greaterOrEqual, lessThan T, iter IterFunc[T]) {
@@ -215,98 +1001,1003 @@ func (t *LLRBTree[T]) Descend(iter IterFunc[T]) {
 		iter)
 }
 
-func (t *LLRBTree[T]) deleteMin(h *node[T]) (_ *node[T], deleted T, ok bool) {
-	if h == nil {
-		return nil, zero[T](), false
-	}
-
-	if h.left == nil {
-		return nil, h.item, true
+// AscendByInsertion calls the iterator for every value in the tree in the
+// order the values were first inserted, until the iterator returns false.
+// Replacing an existing item keeps its original position. It takes O(n log n)
+// time and O(n) extra memory to order a snapshot of the items.
+//
+// It panics unless the tree was created with WithInsertionOrder.
+func (t *LLRBTree[T]) AscendByInsertion(iter IterFunc[T]) {
+	if !t.opts.insertionOrder {
+		panic("llrb: insertion order is not tracked")
 	}
-
-	if !isRed(h.left) && !isRed(h.left.left) {
-		h = moveRedLeft(h)
+	nodes := t.nodes()
+	slices.SortFunc(nodes, func(a, b *node[T]) int {
+		return cmp.Compare(t.order[a], t.order[b])
+	})
+	for _, h := range nodes {
+		if !iter(h.item) {
+			return
+		}
 	}
+}
 
-	h.left, deleted, ok = t.deleteMin(h.left)
+// CDF returns the cumulative distribution of the items: for every item in
+// ascending order, the number of items less than or equal to it. The counts
+// therefore run from 1 to Len.
+func (t *LLRBTree[T]) CDF() []ItemCount[T] {
+	cdf := make([]ItemCount[T], 0, t.len)
+	t.Ascend(func(item T) bool {
+		cdf = append(cdf, ItemCount[T]{Item: item, Count: len(cdf) + 1})
+		return true
+	})
+	return cdf
+}
 
-	return fixUp(h), deleted, ok
+// Histogram counts the items falling into the buckets delimited by the
+// given edges, which must be sorted in ascending order. It returns
+// len(edges)+1 counts: the items less than edges[0], the items in every
+// half-open interval [edges[i-1], edges[i]), and the items greater than or
+// equal to the last edge. Each edge is located with the subtree sizes, so it
+// runs in O(m log n) for m edges regardless of the number of items.
+func (t *LLRBTree[T]) Histogram(edges []T) []int {
+	counts := make([]int, len(edges)+1)
+	prev := 0
+	for i, edge := range edges {
+		rank, _ := t.rank(edge)
+		counts[i] = rank - prev
+		prev = rank
+	}
+	counts[len(edges)] = t.len - prev
+	return counts
+}
+
+// FindInRange returns the first item within the range [greaterOrEqual,
+// lessThan), in ascending order, that satisfies pred, stopping as soon as it
+// is found. It returns (zeroValue, false) if no item in the range matches.
+func (t *LLRBTree[T]) FindInRange(
+	greaterOrEqual, lessThan T,
+	pred func(T) bool,
+) (found T, ok bool) {
+	t.AscendRange(greaterOrEqual, lessThan, func(item T) bool {
+		if pred(item) {
+			found, ok = item, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// SumRangeFunc returns the sum of value(item) over the items within the range
+// [greaterOrEqual, lessThan). It visits every item in the range.
+func (t *LLRBTree[T]) SumRangeFunc(greaterOrEqual, lessThan T, value func(T) float64) float64 {
+	var sum float64
+	t.AscendRange(greaterOrEqual, lessThan, func(item T) bool {
+		sum += value(item)
+		return true
+	})
+	return sum
+}
+
+// Partition splits the items of the tree, in ascending order, into n
+// contiguous buckets whose sizes differ by at most one. If n is greater than
+// the number of items, the trailing buckets are empty. It returns nil if n is
+// not positive.
+func (t *LLRBTree[T]) Partition(n int) [][]T {
+	if n <= 0 {
+		return nil
+	}
+	items := t.items()
+	buckets := make([][]T, n)
+	for i := range buckets {
+		lo, hi := i*len(items)/n, (i+1)*len(items)/n
+		buckets[i] = items[lo:hi:hi]
+	}
+	return buckets
+}
+
+// Items returns a sequence that yields every value in the tree in ascending
+// order. It walks the tree in place, without copying the items, and stops
+// as soon as the consumer breaks out of the loop. It is the canonical
+// ascending iterator the other sequence helpers are built on.
+func (t *LLRBTree[T]) Items() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		t.iterateAsc(t.root, nullItem[T]{}, nullItem[T]{}, yield)
+	}
+}
+
+// All returns a sequence that yields every value in the tree in ascending
+// order, for use with range-over-func loops. It is the same as Items.
+func (t *LLRBTree[T]) All() iter.Seq[T] {
+	return t.Items()
+}
+
+// Backward returns a sequence that yields every value in the tree in
+// descending order. Like Items, it walks the tree in place and stops as soon
+// as the consumer breaks out of the loop.
+func (t *LLRBTree[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		t.iterateDesc(t.root, nullItem[T]{}, nullItem[T]{}, yield)
+	}
+}
+
+// AscendLimit calls the iterator for at most n values in the tree in
+// ascending order, stopping earlier if the iterator returns false.
+func (t *LLRBTree[T]) AscendLimit(n int, iter IterFunc[T]) {
+	if n <= 0 {
+		return
+	}
+	t.Ascend(func(item T) bool {
+		n--
+		return iter(item) && n > 0
+	})
+}
+
+// DescendLimit calls the iterator for at most n values in the tree in
+// descending order, stopping earlier if the iterator returns false.
+func (t *LLRBTree[T]) DescendLimit(n int, iter IterFunc[T]) {
+	if n <= 0 {
+		return
+	}
+	t.Descend(func(item T) bool {
+		n--
+		return iter(item) && n > 0
+	})
+}
+
+// DescendIndexed calls the iterator for every value in the tree in
+// descending order, along with its zero-based index counted from the largest
+// item, until the iterator returns false.
+func (t *LLRBTree[T]) DescendIndexed(iter func(index int, item T) bool) {
+	i := 0
+	t.Descend(func(item T) bool {
+		i++
+		return iter(i-1, item)
+	})
+}
+
+// AscendUntilStop calls the iterator for every value in the tree in
+// ascending order, until the iterator returns false. It returns the item for
+// which the iterator returned false and true, or (zeroValue, false) if the
+// iteration completed.
+func (t *LLRBTree[T]) AscendUntilStop(iter IterFunc[T]) (stoppedAt T, stopped bool) {
+	t.Ascend(func(item T) bool {
+		if !iter(item) {
+			stoppedAt, stopped = item, true
+			return false
+		}
+		return true
+	})
+	return stoppedAt, stopped
+}
+
+// ctxCheckInterval is the number of items AscendCtx visits between two
+// checks of its context.
+const ctxCheckInterval = 256
+
+// AscendCtx calls the iterator for every value in the tree in ascending
+// order, until the iterator returns false or ctx is done. The context is
+// checked before the first item and then every 256 items, so a few more items
+// may be visited after it is cancelled. It returns ctx.Err() if the iteration
+// was cut short by the context, or nil otherwise.
+func (t *LLRBTree[T]) AscendCtx(ctx context.Context, iter IterFunc[T]) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	n := 0
+	t.Ascend(func(item T) bool {
+		if n++; n%ctxCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		return iter(item)
+	})
+	return err
+}
+
+// AscendBuffered calls the iterator for every value in the tree in ascending
+// order, until the iterator returns false, like Ascend. The items are first
+// gathered into a buffer of bufSize items with a non-recursive walk, and the
+// iterator is then called over the buffer, which is reused for every batch.
+// It panics if bufSize is not positive.
+func (t *LLRBTree[T]) AscendBuffered(bufSize int, iter IterFunc[T]) {
+	if bufSize <= 0 {
+		panic("llrb: non-positive buffer size")
+	}
+	buf := make([]T, 0, min(bufSize, t.len))
+	c := newCursor(t.root)
+	for {
+		buf = buf[:0]
+		for len(buf) < bufSize {
+			item, ok := c.next()
+			if !ok {
+				break
+			}
+			buf = append(buf, item)
+		}
+		for _, item := range buf {
+			if !iter(item) {
+				return
+			}
+		}
+		if len(buf) < bufSize {
+			return
+		}
+	}
+}
+
+// AscendShuffled calls the iterator for every value in the tree in a random
+// order drawn from rng, until the iterator returns false. The same seed
+// yields the same order for the same contents. It shuffles a snapshot of the
+// items, which takes O(n) extra memory.
+func (t *LLRBTree[T]) AscendShuffled(rng *rand.Rand, iter IterFunc[T]) {
+	items := t.items()
+	rng.Shuffle(len(items), func(i, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+	for _, item := range items {
+		if !iter(item) {
+			return
+		}
+	}
+}
+
+// WeightedSample returns an item of the tree drawn from rng with probability
+// proportional to weight(item), and true, or (zeroValue, false) if the tree
+// holds no item of positive weight. Items of zero or negative weight are
+// never selected. It makes a single in-order pass with weighted reservoir
+// sampling, so it takes O(n) time and no extra memory.
+func (t *LLRBTree[T]) WeightedSample(weight func(T) float64, rng *rand.Rand) (T, bool) {
+	var (
+		sample T
+		total  float64
+	)
+	t.Ascend(func(item T) bool {
+		w := weight(item)
+		if w <= 0 {
+			return true
+		}
+		total += w
+		if rng.Float64()*total < w {
+			sample = item
+		}
+		return true
+	})
+	return sample, total > 0
+}
+
+// AscendStride calls the iterator for every k-th item in ascending order,
+// that is the items at indices 0, k, 2k, ..., until the iterator returns
+// false. Each item is located from the root using the subtree sizes, so the
+// walk takes O((n/k) log n). It panics if k is not positive.
+func (t *LLRBTree[T]) AscendStride(k int, iter IterFunc[T]) {
+	if k <= 0 {
+		panic("llrb: non-positive stride")
+	}
+	if k == 1 {
+		t.Ascend(iter)
+		return
+	}
+	for i := 0; i < t.len; i += k {
+		if !iter(t.at(i).item) {
+			return
+		}
+	}
+}
+
+// Next returns the item following the one at the zero-based index k in
+// ascending order, that is the item at index k+1, or (zeroValue, false) if
+// there is no such item.
+func (t *LLRBTree[T]) Next(k int) (T, bool) {
+	return t.itemAt(k + 1)
+}
+
+// Prev returns the item preceding the one at the zero-based index k in
+// ascending order, that is the item at index k-1, or (zeroValue, false) if
+// there is no such item.
+func (t *LLRBTree[T]) Prev(k int) (T, bool) {
+	return t.itemAt(k - 1)
+}
+
+func (t *LLRBTree[T]) itemAt(i int) (T, bool) {
+	h := t.at(i)
+	if h == nil {
+		return zero[T](), false
+	}
+	return h.item, true
+}
+
+// Slice returns the items at the zero-based indices [from, to) in ascending
+// order. The range is clamped to [0, Len). The walk starts by seeking the
+// item at from with the subtree sizes, so it runs in O(log n + to - from).
+func (t *LLRBTree[T]) Slice(from, to int) []T {
+	from, to = max(from, 0), min(to, t.len)
+	if from >= to {
+		return []T{}
+	}
+	items := make([]T, 0, to-from)
+	c := newCursorAt(t.root, from)
+	for len(items) < to-from {
+		item, _ := c.next()
+		items = append(items, item)
+	}
+	return items
+}
+
+// Around returns up to k items in ascending order centered on the position of
+// the given key: about k/2 items before it and the rest from the key on. The
+// key need not exist in the tree. Near the ends of the tree, the window is
+// shifted so that k items are still returned when possible.
+func (t *LLRBTree[T]) Around(key T, k int) []T {
+	if k <= 0 {
+		return nil
+	}
+	k = min(k, t.len)
+	pos, _ := t.rank(key)
+	start := max(0, min(pos-k/2, t.len-k))
+
+	items := make([]T, 0, k)
+	c := newCursorAt(t.root, start)
+	for len(items) < k {
+		item, _ := c.next()
+		items = append(items, item)
+	}
+	return items
+}
+
+// LevelOrder returns the items of the tree in breadth-first order, from the
+// root down to the deepest level. Passing the result to FromLevelOrder
+// reconstructs a tree of the same shape.
+func (t *LLRBTree[T]) LevelOrder() []T {
+	items := make([]T, 0, t.len)
+	if t.root == nil {
+		return items
+	}
+	queue := []*node[T]{t.root}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		items = append(items, h.item)
+		if h.left != nil {
+			queue = append(queue, h.left)
+		}
+		if h.right != nil {
+			queue = append(queue, h.right)
+		}
+	}
+	return items
+}
+
+// Checksum returns a hash of the contents of the tree that depends on both
+// the items and their order. It feeds hash(item) for every item, in ascending
+// order, into a 64-bit FNV-1a hash. Trees holding equal items, as seen by
+// hash, have equal checksums, and any change to the items is very likely to
+// change it.
+func (t *LLRBTree[T]) Checksum(hash func(T) uint64) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	t.Ascend(func(item T) bool {
+		binary.LittleEndian.PutUint64(buf[:], hash(item))
+		h.Write(buf[:])
+		return true
+	})
+	return h.Sum64()
+}
+
+// ForEachPostOrder calls fn for every item of the tree in post-order, that is
+// every node after both of its subtrees. It is the safe order for releasing
+// resources attached to items when tearing the tree down, since no item is
+// visited before the ones below it. The tree must not be modified by fn.
+func (t *LLRBTree[T]) ForEachPostOrder(fn func(T)) {
+	var walk func(h *node[T])
+	walk = func(h *node[T]) {
+		if h == nil {
+			return
+		}
+		walk(h.left)
+		walk(h.right)
+		fn(h.item)
+	}
+	walk(t.root)
+}
+
+// Reconcile compares the tree with the desired items, which must be sorted in
+// ascending order and contain no duplicates, and returns the items that
+// would have to be added to and removed from the tree to make it hold
+// exactly the desired items. The tree is not modified. It runs in a single
+// linear merge of the tree and the slice.
+func (t *LLRBTree[T]) Reconcile(desired []T) (toAdd, toRemove []T) {
+	c := newCursor(t.root)
+	i := 0
+	for {
+		x, ok := c.peek()
+		if !ok {
+			return append(toAdd, desired[i:]...), toRemove
+		}
+		if i == len(desired) {
+			toRemove = append(toRemove, x)
+			c.next()
+			continue
+		}
+		cmp := t.compare(x, desired[i])
+		if cmp < 0 {
+			toRemove = append(toRemove, x)
+			c.next()
+		} else if cmp > 0 {
+			toAdd = append(toAdd, desired[i])
+			i++
+		} else {
+			c.next()
+			i++
+		}
+	}
+}
+
+// ApplyDesired modifies the tree to hold exactly the desired items, which
+// must be sorted in ascending order and contain no duplicates, and returns
+// the number of items added and removed. Items already in the tree are kept
+// as they are. Between BeginBulk and EndBulk, the additions are only
+// buffered, as by MergeSortedSlice, and not counted.
+func (t *LLRBTree[T]) ApplyDesired(desired []T) (added, removed int) {
+	toAdd, toRemove := t.Reconcile(desired)
+	for _, item := range toRemove {
+		t.Delete(item)
+	}
+	return t.MergeSortedSlice(toAdd), len(toRemove)
+}
+
+// PageAfter returns up to pageSize items strictly greater than key in
+// ascending order, for keyset pagination. It also returns the last item of
+// the page, to be passed as key to fetch the next page, and whether more
+// items follow the page. It panics if pageSize is not positive.
+func (t *LLRBTree[T]) PageAfter(key T, pageSize int) (page []T, next T, hasMore bool) {
+	if pageSize <= 0 {
+		panic("llrb: non-positive page size")
+	}
+	c := t.seek(key, false)
+	for len(page) < pageSize {
+		item, ok := c.next()
+		if !ok {
+			break
+		}
+		page = append(page, item)
+		next = item
+	}
+	_, hasMore = c.peek()
+	return page, next, hasMore
+}
+
+// Overlaps reports whether the two trees share any item according to the
+// compare function of t. It walks both trees in lockstep and stops at the
+// first common item.
+func (t *LLRBTree[T]) Overlaps(other *LLRBTree[T]) bool {
+	a, b := newCursor(t.root), newCursor(other.root)
+	for {
+		x, okx := a.peek()
+		y, oky := b.peek()
+		if !okx || !oky {
+			return false
+		}
+		cmp := t.compare(x, y)
+		if cmp == 0 {
+			return true
+		} else if cmp < 0 {
+			a.next()
+		} else {
+			b.next()
+		}
+	}
+}
+
+// EqualsSorted reports whether the in-order items of the tree are exactly the
+// given items, according to the compare function of the tree. The items must
+// be sorted in ascending order and contain no duplicates, otherwise the
+// result is meaningless. It checks the lengths first and then compares the
+// items in a single linear pass.
+func (t *LLRBTree[T]) EqualsSorted(items []T) bool {
+	if len(items) != t.len {
+		return false
+	}
+	c := newCursor(t.root)
+	for _, item := range items {
+		x, _ := c.next()
+		if t.compare(x, item) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// UnionWith returns a new tree holding the union of the items of a and b,
+// ordered by the compare function of a and created with the same options.
+// When both trees hold equal items x and y, combine(x, y) is stored instead.
+// It merges the two trees in a single linear pass and builds the result in
+// O(n + m).
+func UnionWith[T any](a, b *LLRBTree[T], combine func(x, y T) T) *LLRBTree[T] {
+	items := make([]T, 0, a.len+b.len)
+	ca, cb := newCursor(a.root), newCursor(b.root)
+	for {
+		x, okx := ca.peek()
+		y, oky := cb.peek()
+		if !okx && !oky {
+			break
+		}
+		var c int
+		switch {
+		case !oky:
+			c = -1
+		case !okx:
+			c = 1
+		default:
+			c = a.compare(x, y)
+		}
+
+		switch {
+		case c < 0:
+			items = append(items, x)
+			ca.next()
+		case c > 0:
+			items = append(items, y)
+			cb.next()
+		default:
+			items = append(items, combine(x, y))
+			ca.next()
+			cb.next()
+		}
+	}
+
+	t := &LLRBTree[T]{compare: a.compare, opts: a.opts}
+	t.MergeSortedSlice(items)
+	return t
+}
+
+// OverlapRatio returns the number of items the two trees have in common,
+// according to the compare function of a, divided by the length of the
+// smaller tree. It is 1 when one tree is contained in the other and 0 when
+// they are disjoint. It returns 0 if either tree is empty. The trees are
+// compared in a single linear merge.
+func OverlapRatio[T any](a, b *LLRBTree[T]) float64 {
+	if a.len == 0 || b.len == 0 {
+		return 0
+	}
+	common := 0
+	ca, cb := newCursor(a.root), newCursor(b.root)
+	for {
+		x, okx := ca.peek()
+		y, oky := cb.peek()
+		if !okx || !oky {
+			break
+		}
+		cmp := a.compare(x, y)
+		if cmp == 0 {
+			common++
+			ca.next()
+			cb.next()
+		} else if cmp < 0 {
+			ca.next()
+		} else {
+			cb.next()
+		}
+	}
+	return float64(common) / float64(min(a.len, b.len))
+}
+
+// DetectCollisions groups the items that compare equal under the given
+// compare function and returns the groups with more than one member, which
+// is the data a tree using that compare function would silently deduplicate.
+// The groups are in ascending order and keep the input order of their
+// members. It is a debugging aid for loose compare functions.
+func DetectCollisions[T any](compare CompareFunc[T], items []T) [][]T {
+	sorted := slices.Clone(items)
+	slices.SortStableFunc(sorted, compare)
+
+	var groups [][]T
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) && compare(sorted[i], sorted[j]) == 0 {
+			j++
+		}
+		if j-i > 1 {
+			groups = append(groups, sorted[i:j:j])
+		}
+		i = j
+	}
+	return groups
+}
+
+// MaxGapFunc returns the pair of consecutive items of the tree, in ascending
+// order, between which dist(lo, hi) is the largest, along with that distance.
+// The first such pair wins ties. It returns ok == false if the tree holds
+// fewer than two items. It visits the items in a single ascending walk.
+func (t *LLRBTree[T]) MaxGapFunc(dist func(a, b T) int64) (lo, hi T, gap int64, ok bool) {
+	var (
+		prev    T
+		started bool
+	)
+	t.Ascend(func(item T) bool {
+		if started {
+			if d := dist(prev, item); !ok || d > gap {
+				lo, hi, gap, ok = prev, item, d, true
+			}
+		}
+		prev, started = item, true
+		return true
+	})
+	return lo, hi, gap, ok
+}
+
+// MaxGap returns the pair of consecutive items of a numeric tree with the
+// largest difference, along with that difference converted to int64, which
+// truncates fractional gaps of floating-point items and caps larger gaps at
+// math.MaxInt64. The differences are computed without overflowing T. The
+// first such pair wins ties. It returns ok == false if the tree holds fewer
+// than two items.
+func MaxGap[T number](t *LLRBTree[T]) (lo, hi T, gap int64, ok bool) {
+	var (
+		prev    T
+		maxU    uint64
+		maxF    float64
+		started bool
+	)
+	t.Ascend(func(item T) bool {
+		if started {
+			if u, f := distance(prev, item); !ok || u > maxU || f > maxF {
+				lo, hi, maxU, maxF, ok = prev, item, u, f, true
+			}
+		}
+		prev, started = item, true
+		return true
+	})
+	switch {
+	case maxU > math.MaxInt64 || maxF >= math.MaxInt64:
+		gap = math.MaxInt64
+	case maxF != 0:
+		gap = int64(maxF)
+	default:
+		gap = int64(maxU)
+	}
+	return lo, hi, gap, ok
+}
+
+// distance returns hi-lo, for lo <= hi, without overflowing T. The difference
+// of two integers always fits in a uint64, returned with a zero float64;
+// floating-point differences are returned as a float64 with a zero uint64.
+// Either way, distances of the same T compare by comparing both results.
+func distance[T number](lo, hi T) (uint64, float64) {
+	var one T = 1
+	if one/2 != 0 {
+		return 0, float64(hi) - float64(lo)
+	}
+	// Signed integers are sign-extended, so the subtraction wraps around to
+	// the exact difference.
+	return uint64(hi) - uint64(lo), 0
+}
+
+// LocalExtrema walks the tree in ascending order and calls iter for every
+// item whose value, as given by the value accessor, is strictly greater than
+// the values of both its neighbors, with isMin false, or strictly less than
+// both, with isMin true, until iter returns false. The smallest and largest
+// items have a single neighbor and are never reported. It suits trees of
+// samples ordered by time, where value reads the measured field.
+func LocalExtrema[T any, V cmp.Ordered](
+	t *LLRBTree[T],
+	value func(T) V,
+	iter func(item T, isMin bool) bool,
+) {
+	var (
+		prev, cur T
+		n         int
+	)
+	t.Ascend(func(next T) bool {
+		n++
+		if n >= 3 {
+			p, c, x := value(prev), value(cur), value(next)
+			switch {
+			case c > p && c > x:
+				if !iter(cur, false) {
+					return false
+				}
+			case c < p && c < x:
+				if !iter(cur, true) {
+					return false
+				}
+			}
+		}
+		prev, cur = cur, next
+		return true
+	})
+}
+
+// Scan walks the tree in ascending order, threading an accumulator through
+// the walk. For every item, step folds it into the accumulator and emit is
+// called with the item and the running accumulator, until emit returns false.
+func Scan[T, A any](
+	tree *LLRBTree[T],
+	init A,
+	step func(acc A, item T) A,
+	emit func(item T, acc A) bool,
+) {
+	acc := init
+	tree.Ascend(func(item T) bool {
+		acc = step(acc, item)
+		return emit(item, acc)
+	})
+}
+
+// validate checks the subtree rooted at h, whose items must be within
+// (lo, hi), and returns its black height.
+func (t *LLRBTree[T]) validate(h *node[T], lo, hi nullItem[T]) (int, error) {
+	if h == nil {
+		return 0, nil
+	}
+	if lo.valid && t.compare(h.item, lo.item) <= 0 ||
+		hi.valid && t.compare(h.item, hi.item) >= 0 {
+		return 0, fmt.Errorf("llrb: item %v is out of order", h.item)
+	}
+	if isRed(h.right) {
+		return 0, fmt.Errorf("llrb: item %v has a right-leaning red link", h.item)
+	}
+	if isRed(h) && isRed(h.left) {
+		return 0, fmt.Errorf("llrb: item %v has two red links in a row", h.item)
+	}
+	lbh, err := t.validate(h.left, lo, nullItem[T]{item: h.item, valid: true})
+	if err != nil {
+		return 0, err
+	}
+	rbh, err := t.validate(h.right, nullItem[T]{item: h.item, valid: true}, hi)
+	if err != nil {
+		return 0, err
+	}
+	if lbh != rbh {
+		return 0, fmt.Errorf("llrb: item %v is not black balanced", h.item)
+	}
+	if n := 1 + size(h.left) + size(h.right); size(h) != n {
+		return 0, fmt.Errorf("llrb: item %v has size %d, want %d", h.item, size(h), n)
+	}
+	if t.opts.trackHeight {
+		if n := 1 + max(height(h.left), height(h.right)); height(h) != n {
+			return 0, fmt.Errorf("llrb: item %v has height %d, want %d",
+				h.item, height(h), n)
+		}
+	}
+	if !isRed(h) {
+		lbh++
+	}
+	return lbh, nil
+}
+
+// deleteMin removes the smallest node in the subtree rooted at h and returns
+// the new subtree root and the removed node.
+func (t *LLRBTree[T]) deleteMin(h *node[T]) (_, deleted *node[T]) {
+	if h == nil {
+		return nil, nil
+	}
+	h = t.mutable(h)
+
+	if h.left == nil {
+		return nil, h
+	}
+
+	if !isRed(h.left) && !isRed(h.left.left) {
+		h = t.moveRedLeft(h)
+	}
+
+	h.left, deleted = t.deleteMin(h.left)
+
+	return t.fixUp(h), deleted
 }
 
 func (t *LLRBTree[T]) deleteMax(h *node[T]) (_ *node[T], deleted T, ok bool) {
 	if h == nil {
 		return nil, zero[T](), false
 	}
+	h = t.mutable(h)
 
 	if isRed(h.left) {
-		h = rotateRight(h)
+		h = t.rotateRight(h)
 	}
 
 	if h.right == nil {
+		t.forget(h)
 		return nil, h.item, true
 	}
 
 	if !isRed(h.right) && !isRed(h.right.left) {
-		h = moveRedRight(h)
+		h = t.moveRedRight(h)
 	}
 
 	h.right, deleted, ok = t.deleteMax(h.right)
 
-	return fixUp(h), deleted, ok
+	return t.fixUp(h), deleted, ok
 }
 
 func (t *LLRBTree[T]) delete(h *node[T], item T) (_ *node[T], deleted T, ok bool) {
 	if h == nil {
 		return nil, zero[T](), false
 	}
+	h = t.mutable(h)
 	if t.compare(item, h.item) < 0 {
 		if h.left == nil {
 			return h, zero[T](), false
 		}
 		if !isRed(h.left) && !isRed(h.left.left) {
-			h = moveRedLeft(h)
+			h = t.moveRedLeft(h)
 		}
 		h.left, deleted, ok = t.delete(h.left, item)
 	} else {
 		if isRed(h.left) {
-			h = rotateRight(h)
+			h = t.rotateRight(h)
 		}
 		if t.compare(item, h.item) == 0 && h.right == nil {
+			t.forget(h)
 			return nil, h.item, true
 		}
 		if h.right != nil && !isRed(h.right) && !isRed(h.right.left) {
-			h = moveRedRight(h)
+			h = t.moveRedRight(h)
 		}
 		if t.compare(item, h.item) == 0 {
-			var rightMin T
-			h.right, rightMin, _ = t.deleteMin(h.right)
-			deleted, h.item = h.item, rightMin
-			ok = true
+			var rightMin *node[T]
+			h.right, rightMin = t.deleteMin(h.right)
+			deleted, ok = h.item, true
+			h.item = rightMin.item
+			if t.opts.insertionOrder {
+				t.setStamp(h, t.order[rightMin])
+				t.forget(rightMin)
+			}
 		} else {
 			h.right, deleted, ok = t.delete(h.right, item)
 		}
 	}
 
-	return fixUp(h), deleted, ok
+	return t.fixUp(h), deleted, ok
 }
 
-func (t *LLRBTree[T]) insert(h *node[T], item T) (_ *node[T], prev T, exist bool) {
+// insert adds the item under h, or replaces an equal item with it. If merge
+// is not nil, an equal item is replaced with merge(existing, item) instead.
+func (t *LLRBTree[T]) insert(
+	h *node[T],
+	item T,
+	merge func(existing, incoming T) T,
+) (_ *node[T], prev T, exist bool) {
 	if h == nil {
-		return newNode(item), zero[T](), false
+		return t.newNode(item), zero[T](), false
 	}
 
+	// Nodes are only copied on the way back up, after every comparison
+	// has succeeded, so that a failing comparison of a tree created with
+	// NewLLRBTreeE leaves the tree untouched.
 	cmp := t.compare(item, h.item)
 	if cmp == 0 {
 		prev = h.item
-		exist = true
+		if merge != nil {
+			item = merge(h.item, item)
+		}
+		h = t.mutable(h)
 		h.item = item
-	} else if cmp < 0 {
-		h.left, prev, exist = t.insert(h.left, item)
+		return h, prev, true
+	}
+
+	var child *node[T]
+	if cmp < 0 {
+		child, prev, exist = t.insert(h.left, item, merge)
+		h = t.mutable(h)
+		h.left = child
 	} else {
-		h.right, prev, exist = t.insert(h.right, item)
+		child, prev, exist = t.insert(h.right, item, merge)
+		h = t.mutable(h)
+		h.right = child
 	}
 
-	return fixUp(h), prev, exist
+	if exist {
+		// Replacing an item in place leaves the shape of the tree, and
+		// the subtree sizes, untouched, so there is nothing to fix up.
+		return h, prev, true
+	}
+	return t.fixUp(h), prev, false
+}
+
+// rank returns the number of items less than the given item, and whether an
+// item equal to it exists in the tree.
+func (t *LLRBTree[T]) rank(item T) (int, bool) {
+	rank := 0
+	x := t.root
+	for x != nil {
+		cmp := t.compare(item, x.item)
+		if cmp == 0 {
+			return rank + size(x.left), true
+		} else if cmp < 0 {
+			x = x.left
+		} else {
+			rank += size(x.left) + 1
+			x = x.right
+		}
+	}
+	return rank, false
+}
+
+// minNode returns the node holding the smallest item, or nil if the tree is
+// empty.
+func (t *LLRBTree[T]) minNode() *node[T] {
+	x := t.root
+	for x != nil && x.left != nil {
+		x = x.left
+	}
+	return x
+}
+
+// maxNode returns the node holding the largest item, or nil if the tree is
+// empty.
+func (t *LLRBTree[T]) maxNode() *node[T] {
+	x := t.root
+	for x != nil && x.right != nil {
+		x = x.right
+	}
+	return x
+}
+
+// at returns the node at the given zero-based index in ascending order, or
+// nil if the index is out of range.
+func (t *LLRBTree[T]) at(i int) *node[T] {
+	if i < 0 || i >= t.len {
+		return nil
+	}
+	x := t.root
+	for x != nil {
+		n := size(x.left)
+		if i == n {
+			return x
+		} else if i < n {
+			x = x.left
+		} else {
+			i -= n + 1
+			x = x.right
+		}
+	}
+	return nil
+}
+
+// newNode creates a red node for a newly added item, stamping it with the
+// next sequence number if insertion order is tracked.
+func (t *LLRBTree[T]) newNode(item T) *node[T] {
+	h := newNode(item)
+	h.owner = t.token
+	if t.opts.insertionOrder {
+		t.seq++
+		t.setStamp(h, t.seq)
+	}
+	if t.opts.trackHeight {
+		h.aug |= 1 << sizeBits
+	}
+	return h
+}
+
+// items returns all the items of the tree in ascending order.
+func (t *LLRBTree[T]) items() []T {
+	items := make([]T, 0, t.len)
+	t.Ascend(func(item T) bool {
+		items = append(items, item)
+		return true
+	})
+	return items
+}
+
+// nodes returns all the nodes of the tree in ascending order.
+func (t *LLRBTree[T]) nodes() []*node[T] {
+	nodes := make([]*node[T], 0, t.len)
+	var walk func(h *node[T])
+	walk = func(h *node[T]) {
+		if h == nil {
+			return
+		}
+		walk(h.left)
+		nodes = append(nodes, h)
+		walk(h.right)
+	}
+	walk(t.root)
+	return nodes
 }
 
 type nullItem[T any] struct {
@@ -360,76 +2051,328 @@ func (t *LLRBTree[T]) iterateDesc(
 	return t.iterateDesc(h.left, start, end, iter)
 }
 
+// cursor walks the nodes of a tree in ascending order, one item at a time.
+// It is used where several trees have to be traversed in lockstep.
+type cursor[T any] struct {
+	stack []*node[T]
+}
+
+func newCursor[T any](root *node[T]) *cursor[T] {
+	c := &cursor[T]{}
+	c.pushLeft(root)
+	return c
+}
+
+// newCursorAt returns a cursor positioned at the given zero-based index in
+// ascending order. The cursor is exhausted if the index is out of range.
+func newCursorAt[T any](root *node[T], i int) *cursor[T] {
+	c := &cursor[T]{}
+	if i < 0 || i >= size(root) {
+		return c
+	}
+	x := root
+	for x != nil {
+		n := size(x.left)
+		if i == n {
+			c.stack = append(c.stack, x)
+			break
+		} else if i < n {
+			c.stack = append(c.stack, x)
+			x = x.left
+		} else {
+			i -= n + 1
+			x = x.right
+		}
+	}
+	return c
+}
+
+// seek returns a cursor positioned at the first item greater than the given
+// one, or greater than or equal to it if inclusive is true.
+func (t *LLRBTree[T]) seek(item T, inclusive bool) *cursor[T] {
+	c := &cursor[T]{}
+	x := t.root
+	for x != nil {
+		cmp := t.compare(x.item, item)
+		if cmp > 0 || inclusive && cmp == 0 {
+			c.stack = append(c.stack, x)
+			x = x.left
+		} else {
+			x = x.right
+		}
+	}
+	return c
+}
+
+func (c *cursor[T]) pushLeft(h *node[T]) {
+	for h != nil {
+		c.stack = append(c.stack, h)
+		h = h.left
+	}
+}
+
+// peek returns the current item without advancing the cursor.
+func (c *cursor[T]) peek() (T, bool) {
+	if len(c.stack) == 0 {
+		return zero[T](), false
+	}
+	return c.stack[len(c.stack)-1].item, true
+}
+
+// next returns the current item and advances the cursor.
+func (c *cursor[T]) next() (T, bool) {
+	h := c.nextNode()
+	if h == nil {
+		return zero[T](), false
+	}
+	return h.item, true
+}
+
+// nextNode returns the current node, or nil if the cursor is exhausted, and
+// advances the cursor.
+func (c *cursor[T]) nextNode() *node[T] {
+	if len(c.stack) == 0 {
+		return nil
+	}
+	h := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	c.pushLeft(h.right)
+	return h
+}
+
 func newNode[T any](item T) *node[T] {
 	return &node[T]{
-		item:  item,
-		color: _red,
+		item: item,
+		aug:  redBit | 1,
+	}
+}
+
+// buildNodes links the given nodes, which must be in ascending order, into a
+// balanced LLRB tree in O(n) and returns its root. The tree is built as a 2-3
+// tree of minimal height, in which 3-nodes are encoded as a black node with a
+// red left child.
+func (t *LLRBTree[T]) buildNodes(nodes []*node[T]) *node[T] {
+	root := t.build(nodes, bits.Len(uint(len(nodes)+1))-1)
+	if root != nil {
+		root.setColor(_black)
 	}
+	return root
 }
 
-func rotateLeft[T any](h *node[T]) *node[T] {
-	x := h.right
+// build builds a 2-3 tree with exactly blackHeight levels from the nodes.
+// 2^blackHeight-1 <= len(nodes) <= 3^blackHeight-1 must hold.
+func (t *LLRBTree[T]) build(nodes []*node[T], blackHeight int) *node[T] {
+	n := len(nodes)
+	if n == 0 {
+		return nil
+	}
+
+	if n-1 <= 2*max3Items(blackHeight-1) {
+		l := (n - 1) / 2
+		h := nodes[l]
+		h.left = t.build(nodes[:l], blackHeight-1)
+		h.right = t.build(nodes[l+1:], blackHeight-1)
+		h.setColor(_black)
+		t.update(h)
+		return h
+	}
+
+	a := (n - 2) / 3
+	b := (n - 2 - a) / 2
+	x, y := nodes[a], nodes[a+1+b]
+	x.left = t.build(nodes[:a], blackHeight-1)
+	x.right = t.build(nodes[a+1:a+1+b], blackHeight-1)
+	x.setColor(_red)
+	t.update(x)
+	y.left = x
+	y.right = t.build(nodes[a+2+b:], blackHeight-1)
+	y.setColor(_black)
+	t.update(y)
+	return y
+}
+
+// max3Items returns the number of items held by a 2-3 tree of the given
+// height made of 3-nodes only, 3^height-1, saturating at math.MaxInt.
+func max3Items(height int) int {
+	n := 1
+	for i := 0; i < height; i++ {
+		if n > math.MaxInt/3 {
+			return math.MaxInt
+		}
+		n *= 3
+	}
+	return n - 1
+}
+
+func (t *LLRBTree[T]) rotateLeft(h *node[T]) *node[T] {
+	if t.opts.countRotations {
+		t.rotations++
+	}
+	x := t.mutable(h.right)
 	h.right = x.left
 	x.left = h
-	x.color = h.color
-	h.color = _red
+	x.setColor(h.color())
+	h.setColor(_red)
+	t.update(h)
+	t.update(x)
 	return x
 }
 
-func rotateRight[T any](h *node[T]) *node[T] {
-	x := h.left
+func (t *LLRBTree[T]) rotateRight(h *node[T]) *node[T] {
+	if t.opts.countRotations {
+		t.rotations++
+	}
+	x := t.mutable(h.left)
 	h.left = x.right
 	x.right = h
-	x.color = h.color
-	h.color = _red
+	x.setColor(h.color())
+	h.setColor(_red)
+	t.update(h)
+	t.update(x)
 	return x
 }
 
-func colorFlip[T any](h *node[T]) {
-	h.color = !h.color
-	h.left.color = !h.left.color
-	h.right.color = !h.right.color
+// colorFlip flips the colors of h and both its children. Like the rotations,
+// it expects h to be owned by the tree, and copies the children if needed.
+func (t *LLRBTree[T]) colorFlip(h *node[T]) {
+	h.left, h.right = t.mutable(h.left), t.mutable(h.right)
+	h.setColor(!h.color())
+	h.left.setColor(!h.left.color())
+	h.right.setColor(!h.right.color())
 }
 
 func isRed[T any](h *node[T]) bool {
 	if h == nil {
 		return false
 	}
-	return h.color
+	return h.color()
 }
 
-func fixUp[T any](h *node[T]) *node[T] {
+func (t *LLRBTree[T]) fixUp(h *node[T]) *node[T] {
 	if isRed(h.right) && !isRed(h.left) {
-		h = rotateLeft(h)
+		h = t.rotateLeft(h)
 	}
 	if isRed(h.left) && isRed(h.left.left) {
-		h = rotateRight(h)
+		h = t.rotateRight(h)
 	}
 	if isRed(h.left) && isRed(h.right) {
-		colorFlip(h)
+		t.colorFlip(h)
 	}
+	t.update(h)
 	return h
 }
 
-func moveRedLeft[T any](h *node[T]) *node[T] {
-	colorFlip(h)
+func (t *LLRBTree[T]) moveRedLeft(h *node[T]) *node[T] {
+	t.colorFlip(h)
 	if isRed(h.right.left) {
-		h.right = rotateRight(h.right)
-		h = rotateLeft(h)
-		colorFlip(h)
+		h.right = t.rotateRight(h.right)
+		h = t.rotateLeft(h)
+		t.colorFlip(h)
 	}
 	return h
 }
 
-func moveRedRight[T any](h *node[T]) *node[T] {
-	colorFlip(h)
+func (t *LLRBTree[T]) moveRedRight(h *node[T]) *node[T] {
+	t.colorFlip(h)
 	if isRed(h.left.left) {
-		h = rotateRight(h)
-		colorFlip(h)
+		h = t.rotateRight(h)
+		t.colorFlip(h)
 	}
 	return h
 }
 
+// The subtree size, height and color of a node are packed into its aug
+// field, so that height tracking costs no memory in trees that don't enable
+// it, and the owner pointer fits in the space a separate color would waste
+// on padding: the size takes the low sizeBits bits, the height, which is at
+// most twice the binary logarithm of the size, the next heightBits bits, and
+// the color the top bit, set for red nodes.
+const (
+	sizeBits   = 55
+	sizeMask   = 1<<sizeBits - 1
+	heightBits = 8
+	heightMask = 1<<heightBits - 1
+	redBit     = 1 << (sizeBits + heightBits)
+)
+
+func height[T any](h *node[T]) int {
+	if h == nil {
+		return 0
+	}
+	return int(h.aug >> sizeBits & heightMask)
+}
+
+func (h *node[T]) color() bool {
+	return h.aug&redBit != 0
+}
+
+func (h *node[T]) setColor(color bool) {
+	if color == _red {
+		h.aug |= redBit
+	} else {
+		h.aug &^= redBit
+	}
+}
+
+func size[T any](h *node[T]) int {
+	if h == nil {
+		return 0
+	}
+	return int(h.aug & sizeMask)
+}
+
+// mutable returns h if it is owned by the tree, or otherwise a copy of h owned
+// by the tree, which the caller must link in place of h. It is the only way
+// nodes shared with a snapshot are copied before being modified.
+func (t *LLRBTree[T]) mutable(h *node[T]) *node[T] {
+	if h.owner == t.token {
+		return h
+	}
+	c := *h
+	c.owner = t.token
+	if t.opts.insertionOrder {
+		t.setStamp(&c, t.order[h])
+		t.forget(h)
+	}
+	return &c
+}
+
+// setStamp records the insertion sequence number of h.
+func (t *LLRBTree[T]) setStamp(h *node[T], seq uint64) {
+	t.ownOrder()
+	t.order[h] = seq
+}
+
+// forget drops the insertion sequence number of h, which has been removed
+// from the tree or replaced by a copy. It does nothing unless insertion order
+// is tracked.
+func (t *LLRBTree[T]) forget(h *node[T]) {
+	if t.opts.insertionOrder {
+		t.ownOrder()
+		delete(t.order, h)
+	}
+}
+
+// ownOrder makes sure the tree has an order map of its own that it may
+// modify, copying the map it shares with a snapshot if needed.
+func (t *LLRBTree[T]) ownOrder() {
+	if t.orderShared {
+		t.order, t.orderShared = maps.Clone(t.order), false
+	} else if t.order == nil {
+		t.order = make(map[*node[T]]uint64)
+	}
+}
+
+// update recomputes the subtree size of h, and its height if tracked, from
+// its children, keeping its color.
+func (t *LLRBTree[T]) update(h *node[T]) {
+	aug := h.aug&redBit | uint64(1+size(h.left)+size(h.right))
+	if t.opts.trackHeight {
+		aug |= uint64(1+max(height(h.left), height(h.right))) << sizeBits
+	}
+	h.aug = aug
+}
+
 func zero[T any]() T {
 	var zero T
 	return zero
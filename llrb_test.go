@@ -5,10 +5,16 @@
 package llrb
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"math"
+	"math/bits"
 	"math/rand"
 	"runtime"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -41,6 +47,218 @@ func TestLLRBTree_insert(t *testing.T) {
 	}
 }
 
+func TestLLRBTree_Clone(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int](WithInsertionOrder(), WithHeightTracking())
+	c := tree.Clone()
+	assert.Equal(0, c.Len())
+	c.ReplaceOrInsert(1)
+	assert.Equal(0, tree.Len())
+
+	for _, x := range rnd(1000, 5000) {
+		tree.ReplaceOrInsert(x)
+	}
+	items := tree.items()
+	c = tree.Clone()
+	assert.Equal(tree.Len(), c.Len())
+	assert.NoError(c.Validate())
+	assert.True(tree.SameStructure(c))
+	assert.Equal(tree.Height(), c.Height())
+
+	var order []int
+	tree.AscendByInsertion(func(x int) bool {
+		order = append(order, x)
+		return true
+	})
+	var cloneOrder []int
+	c.AscendByInsertion(func(x int) bool {
+		cloneOrder = append(cloneOrder, x)
+		return true
+	})
+	assert.Equal(order, cloneOrder)
+
+	for _, x := range rnd(500, 5000) {
+		c.Delete(x)
+	}
+	for _, x := range rnd(500, 5000) {
+		c.ReplaceOrInsert(x + 5000)
+	}
+	assert.NoError(c.Validate())
+	assert.NoError(tree.Validate())
+	assert.Equal(items, tree.items())
+
+	tree.Clear()
+	assert.NotZero(c.Len())
+}
+
+func TestLLRBTree_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int](WithInsertionOrder(), WithHeightTracking())
+	snap := tree.Snapshot()
+	tree.ReplaceOrInsert(1)
+	assert.Equal(0, snap.Len())
+	snap.ReplaceOrInsert(2)
+	assert.Equal([]int{1}, tree.items())
+	assert.Equal([]int{2}, snap.items())
+
+	tree = NewOrdered[int](WithInsertionOrder(), WithHeightTracking())
+	for _, x := range rnd(1000, 5000) {
+		tree.ReplaceOrInsert(x)
+	}
+	levels := tree.LevelOrder()
+	snap = tree.Snapshot()
+	assert.True(tree.SameStructure(snap))
+	assert.Same(tree.root, snap.root)
+
+	for _, x := range rnd(500, 5000) {
+		tree.Delete(x)
+	}
+	for _, x := range rnd(500, 10000) {
+		tree.ReplaceOrInsert(x)
+	}
+	tree.DeleteMin()
+	tree.DeleteMax()
+	tree.MergeSortedSlice(seq(100))
+	tree.RecomputeSizes()
+	assert.NoError(tree.Validate())
+	assert.NoError(snap.Validate())
+	assert.Equal(levels, snap.LevelOrder())
+
+	// The snapshot can be written to without affecting the tree.
+	levels = tree.LevelOrder()
+	snap2 := snap.Snapshot()
+	for _, x := range rnd(1000, 5000) {
+		snap.ReplaceOrInsert(-x)
+	}
+	for _, x := range rnd(200, 5000) {
+		snap.Delete(x)
+	}
+	assert.NoError(snap.Validate())
+	assert.NoError(snap2.Validate())
+	assert.Equal(levels, tree.LevelOrder())
+	assert.NotEqual(snap2.LevelOrder(), snap.LevelOrder())
+
+	// Taking a snapshot is O(1), and a single insert copies O(log n) nodes.
+	counts := func(tr *LLRBTree[int]) map[*node[int]]bool {
+		seen := make(map[*node[int]]bool)
+		for _, h := range tr.nodes() {
+			seen[h] = true
+		}
+		return seen
+	}
+	before := counts(tree)
+	snap3 := tree.Snapshot()
+	tree.ReplaceOrInsert(-1)
+	fresh := 0
+	for h := range counts(tree) {
+		if !before[h] {
+			fresh++
+		}
+	}
+	assert.LessOrEqual(fresh, 3*bits.Len(uint(tree.Len())))
+	assert.Equal(len(before), snap3.Len())
+	assert.False(snap3.Has(-1))
+}
+
+func TestLLRBTree_RotationCount(t *testing.T) {
+	assert := assert.New(t)
+
+	const N = 1000
+	tree := NewOrdered[int]()
+	for _, x := range seq(N) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Zero(tree.RotationCount())
+
+	tree = NewOrdered[int](WithRotationCounter())
+	assert.Zero(tree.RotationCount())
+	for _, x := range seq(N) {
+		tree.ReplaceOrInsert(x)
+	}
+	ascending := tree.RotationCount()
+	assert.NotZero(ascending)
+	assert.LessOrEqual(ascending, uint64(2*N))
+
+	for _, x := range seq(N) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal(ascending, tree.RotationCount())
+
+	for _, x := range seq(N) {
+		tree.Delete(x)
+	}
+	assert.Greater(tree.RotationCount(), ascending)
+}
+
+func TestLLRBTree_replace_allocs(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, opts := range [][]Option{nil, {WithInsertionOrder(), WithHeightTracking()}} {
+		tree := NewOrdered[int](opts...)
+		for _, x := range shuffle(seq(1000)) {
+			tree.ReplaceOrInsert(x)
+		}
+		a := shuffle(seq(1000))
+		assert.Zero(testing.AllocsPerRun(10, func() {
+			for _, x := range a {
+				tree.ReplaceOrInsert(x)
+			}
+		}))
+		assert.Equal(1000, tree.Len())
+		assert.NoError(tree.Validate())
+	}
+}
+
+func TestLLRBTree_InsertOrMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	type tally struct {
+		key   string
+		count int
+	}
+	tree := New(func(a, b tally) int {
+		return strings.Compare(a.key, b.key)
+	})
+	merge := func(existing, incoming tally) tally {
+		return tally{existing.key, existing.count + incoming.count}
+	}
+
+	assert.Equal(tally{"b", 1}, tree.InsertOrMerge(tally{"b", 1}, merge))
+	assert.Equal(tally{"a", 2}, tree.InsertOrMerge(tally{"a", 2}, merge))
+	assert.Equal(tally{"b", 4}, tree.InsertOrMerge(tally{"b", 3}, merge))
+	assert.Equal(tally{"c", 1}, tree.InsertOrMerge(tally{"c", 1}, merge))
+	assert.Equal(tally{"a", 7}, tree.InsertOrMerge(tally{"a", 5}, merge))
+	assert.Equal(3, tree.Len())
+	assert.Equal([]tally{{"a", 7}, {"b", 4}, {"c", 1}}, tree.items())
+
+	counts := New(func(a, b ItemCount[int]) int {
+		return cmp.Compare(a.Item, b.Item)
+	})
+	inc := func(existing, _ ItemCount[int]) ItemCount[int] {
+		existing.Count++
+		return existing
+	}
+	a := rnd(1000, 100)
+	for _, x := range a {
+		counts.InsertOrMerge(ItemCount[int]{x, 1}, inc)
+	}
+	assert.Equal(uniq(a), counts.Len())
+	assert.NoError(counts.Validate())
+	total := 0
+	counts.Ascend(func(ic ItemCount[int]) bool {
+		total += ic.Count
+		return true
+	})
+	assert.Equal(len(a), total)
+
+	tree.BeginBulk()
+	assert.PanicsWithValue("llrb: InsertOrMerge during bulk insert", func() {
+		tree.InsertOrMerge(tally{"d", 1}, merge)
+	})
+}
+
 func TestLLRBTree_random_insert_delete(t *testing.T) {
 	assert := assert.New(t)
 	tree := NewOrdered[int]()
@@ -56,6 +274,7 @@ func TestLLRBTree_random_insert_delete(t *testing.T) {
 		}
 		assert.Equal(uniqNums, tree.Len())
 		assertMaxDepth(t, tree)
+		assertSizes(t, tree)
 	}
 
 	insert()
@@ -65,22 +284,31 @@ func TestLLRBTree_random_insert_delete(t *testing.T) {
 		assert.True(ok)
 	}
 
-	for _, x := range a {
+	for i, x := range a {
 		_, _ = tree.Delete(x)
+		if i%1000 == 0 {
+			assertSizes(t, tree)
+		}
 	}
 	assert.Equal(0, tree.Len())
 	assert.Nil(tree.root)
 
 	insert()
-	for range a {
+	for i := range a {
 		_, _ = tree.DeleteMin()
+		if i%1000 == 0 {
+			assertSizes(t, tree)
+		}
 	}
 	assert.Equal(0, tree.Len())
 	assert.Nil(tree.root)
 
 	insert()
-	for range a {
+	for i := range a {
 		_, _ = tree.DeleteMax()
+		if i%1000 == 0 {
+			assertSizes(t, tree)
+		}
 	}
 	assert.Equal(0, tree.Len())
 	assert.Nil(tree.root)
@@ -192,125 +420,2283 @@ func TestLLRBTree_iterator_break(t *testing.T) {
 	assert.Equal([]int{1, 2, 3, 4, 5}, collect)
 }
 
-func BenchmarkLLRBTree_insert_random(b *testing.B) {
-	const L = 50000
-	assert := assert.New(b)
+func TestLLRBTree_MinMax(t *testing.T) {
+	assert := assert.New(t)
 
-	a := seq(L)
+	tree := NewOrdered[int]()
+	_, ok := tree.Min()
+	assert.False(ok)
+	_, ok = tree.Max()
+	assert.False(ok)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		shuffle(a)
-		runtime.GC()
-		b.StartTimer()
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		tree.ReplaceOrInsert(x)
+	}
+	lo, ok := tree.Min()
+	assert.True(ok)
+	assert.Equal(slices.Min(a), lo)
+	hi, ok := tree.Max()
+	assert.True(ok)
+	assert.Equal(slices.Max(a), hi)
+	assert.Equal(uniq(a), tree.Len())
 
-		t := NewOrdered[int]()
-		for _, x := range a {
-			prev, exist := t.ReplaceOrInsert(x)
+	tree.DeleteMin()
+	tree.DeleteMax()
+	items := tree.items()
+	lo, _ = tree.Min()
+	hi, _ = tree.Max()
+	assert.Equal(items[0], lo)
+	assert.Equal(items[len(items)-1], hi)
+}
 
-			assert.Equal(0, prev)
-			assert.False(exist)
-		}
-		assert.Equal(L, t.Len())
+func TestLLRBTree_CeilFloorWithIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range shuffle([]int{10, 20, 30, 40, 50}) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	for i, x := range []int{10, 20, 30, 40, 50} {
+		item, index, ok := tree.CeilWithIndex(x)
+		assert.Equal(x, item)
+		assert.Equal(i, index)
+		assert.True(ok)
+
+		item, index, ok = tree.FloorWithIndex(x)
+		assert.Equal(x, item)
+		assert.Equal(i, index)
+		assert.True(ok)
 	}
+
+	item, index, ok := tree.CeilWithIndex(25)
+	assert.Equal(30, item)
+	assert.Equal(2, index)
+	assert.True(ok)
+
+	item, index, ok = tree.FloorWithIndex(25)
+	assert.Equal(20, item)
+	assert.Equal(1, index)
+	assert.True(ok)
+
+	item, index, ok = tree.CeilWithIndex(5)
+	assert.Equal(10, item)
+	assert.Equal(0, index)
+	assert.True(ok)
+
+	item, index, ok = tree.FloorWithIndex(5)
+	assert.Zero(item)
+	assert.Equal(-1, index)
+	assert.False(ok)
+
+	item, index, ok = tree.CeilWithIndex(55)
+	assert.Zero(item)
+	assert.Equal(-1, index)
+	assert.False(ok)
+
+	item, index, ok = tree.FloorWithIndex(55)
+	assert.Equal(50, item)
+	assert.Equal(4, index)
+	assert.True(ok)
+
+	_, _, ok = NewOrdered[int]().CeilWithIndex(1)
+	assert.False(ok)
 }
 
-func BenchmarkLLRBTree_insert_ascending(b *testing.B) {
-	const L = 50000
-	assert := assert.New(b)
+func TestLLRBTree_Validate(t *testing.T) {
+	assert := assert.New(t)
 
-	a := seq(L)
+	tree := NewOrdered[int]()
+	assert.NoError(tree.Validate())
+	for _, x := range rnd(1000, 2000) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.NoError(tree.Validate())
+	for _, x := range rnd(1000, 2000) {
+		tree.Delete(x)
+	}
+	assert.NoError(tree.Validate())
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		runtime.GC()
-		b.StartTimer()
+	tree.root.left.aug += 3
+	assert.Error(tree.Validate())
+	tree.RecomputeSizes()
+	assert.NoError(tree.Validate())
 
-		t := NewOrdered[int]()
-		for _, x := range a {
-			prev, exist := t.ReplaceOrInsert(x)
+	tree.len++
+	assert.Error(tree.Validate())
+	tree.RecomputeSizes()
+	assert.NoError(tree.Validate())
 
-			assert.Equal(0, prev)
-			assert.False(exist)
+	tree.root.left.item, tree.root.right.item = tree.root.right.item, tree.root.left.item
+	assert.Error(tree.Validate())
+}
+
+func TestLLRBTree_AscendByInsertion(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int](WithInsertionOrder())
+	for _, x := range []int{5, 2, 8, 1, 9, 3} {
+		tree.ReplaceOrInsert(x)
+	}
+	tree.ReplaceOrInsert(2)
+	tree.Delete(8)
+	tree.ReplaceOrInsert(8)
+	tree.Delete(5)
+	assert.NoError(tree.Validate())
+
+	var collect []int
+	tree.AscendByInsertion(func(x int) bool {
+		collect = append(collect, x)
+		return true
+	})
+	assert.Equal([]int{2, 1, 9, 3, 8}, collect)
+
+	collect = collect[:0]
+	tree.AscendByInsertion(func(x int) bool {
+		collect = append(collect, x)
+		return len(collect) < 2
+	})
+	assert.Equal([]int{2, 1}, collect)
+
+	a := shuffle(seq(1000))
+	tree = NewOrdered[int](WithInsertionOrder())
+	for _, x := range a {
+		tree.ReplaceOrInsert(x)
+	}
+	for x := 1; x <= 1000; x += 3 {
+		tree.Delete(x)
+	}
+	var want []int
+	for _, x := range a {
+		if x%3 != 1 {
+			want = append(want, x)
 		}
-		assert.Equal(L, t.Len())
 	}
+	collect = collect[:0]
+	tree.AscendByInsertion(func(x int) bool {
+		collect = append(collect, x)
+		return true
+	})
+	assert.Equal(want, collect)
+	assert.Len(tree.order, tree.Len())
+
+	snap := tree.Snapshot()
+	tree.DeleteMin()
+	tree.ReplaceOrInsert(1)
+	collect = collect[:0]
+	snap.AscendByInsertion(func(x int) bool {
+		collect = append(collect, x)
+		return true
+	})
+	assert.Equal(want, collect)
+	collect = collect[:0]
+	tree.AscendByInsertion(func(x int) bool {
+		collect = append(collect, x)
+		return true
+	})
+	want = append(slices.DeleteFunc(want, func(x int) bool { return x == 2 }), 1)
+	assert.Equal(want, collect)
+	assert.Len(tree.order, tree.Len())
+	assert.Len(snap.order, snap.Len())
+
+	assert.Nil(NewOrdered[int]().order)
+
+	assert.PanicsWithValue("llrb: insertion order is not tracked", func() {
+		NewOrdered[int]().AscendByInsertion(func(int) bool { return true })
+	})
 }
 
-func BenchmarkLLRBTree_get_random(b *testing.B) {
-	const L = 50000
-	assert := assert.New(b)
+func TestLLRBTree_MergeSortedSlice(t *testing.T) {
+	assert := assert.New(t)
 
-	a := seq(L)
-	getOps := seq(2 * L)
+	for _, sizes := range [][2]int{{0, 0}, {0, 100}, {100, 0}, {1000, 10}, {1000, 1000}} {
+		tree := NewOrdered[int]()
+		for _, x := range rnd(sizes[0], 4*sizes[0]+1) {
+			tree.ReplaceOrInsert(x)
+		}
+		items := rnd(sizes[1], 4*sizes[1]+1)
+		slices.Sort(items)
+		items = slices.Compact(items)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		shuffle(a)
-		shuffle(getOps)
-		t := NewOrdered[int]()
-		for _, x := range a {
-			_, _ = t.ReplaceOrInsert(x)
+		want := make(map[int]struct{})
+		tree.Ascend(func(x int) bool {
+			want[x] = struct{}{}
+			return true
+		})
+		n := len(want)
+		for _, x := range items {
+			want[x] = struct{}{}
 		}
-		runtime.GC()
-		b.StartTimer()
 
-		for _, x := range getOps {
-			item, ok := t.Get(x)
-			if x <= L {
-				assert.Equal(x, item)
-				assert.True(ok)
-			} else {
-				assert.Equal(0, item)
-				assert.False(ok)
-			}
+		assert.Equal(len(want)-n, tree.MergeSortedSlice(items))
+		assert.Equal(len(want), tree.Len())
+		assert.NoError(tree.Validate())
+		assertMaxDepth(t, tree)
+
+		var collect []int
+		tree.Ascend(func(x int) bool {
+			collect = append(collect, x)
+			return true
+		})
+		assert.True(slices.IsSorted(collect))
+		assert.Len(collect, len(want))
+		for _, x := range collect {
+			assert.Contains(want, x)
 		}
 	}
 }
 
-func BenchmarkLLRBTree_get_ascending(b *testing.B) {
-	const L = 50000
-	assert := assert.New(b)
+func TestLLRBTree_bulk(t *testing.T) {
+	assert := assert.New(t)
 
-	a := seq(L)
-	getOps := seq(2 * L)
+	type pair struct{ k, v int }
+	tree := New(func(a, b pair) int {
+		return a.k - b.k
+	})
+	for _, x := range []int{1, 3, 5} {
+		tree.ReplaceOrInsert(pair{x, 0})
+	}
+	assert.Equal(0, tree.EndBulk())
 
-	t := NewOrdered[int]()
+	tree.BeginBulk()
+	for i, x := range []int{4, 2, 3, 4, 6} {
+		prev, exist := tree.ReplaceOrInsert(pair{x, i + 1})
+		assert.Zero(prev)
+		assert.False(exist)
+	}
+	assert.Equal(3, tree.Len())
+	assert.False(tree.Has(pair{k: 2}))
+	tree.Delete(pair{k: 5})
+
+	assert.Equal(3, tree.EndBulk())
+	assert.Equal([]pair{{1, 0}, {2, 2}, {3, 3}, {4, 4}, {6, 5}}, tree.items())
+	assert.NoError(tree.Validate())
+
+	tree.ReplaceOrInsert(pair{7, 0})
+	assert.Equal(6, tree.Len())
+
+	ints := NewOrdered[int]()
+	a := rnd(10000, 20000)
+	ints.BeginBulk()
 	for _, x := range a {
-		_, _ = t.ReplaceOrInsert(x)
+		ints.ReplaceOrInsert(x)
 	}
-	runtime.GC()
+	assert.Equal(0, ints.Len())
+	assert.Equal(uniq(a), ints.EndBulk())
+	assert.Equal(uniq(a), ints.Len())
+	assert.NoError(ints.Validate())
+	assertMaxDepth(t, ints)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		for _, x := range getOps {
-			item, ok := t.Get(x)
-			if x <= L {
-				assert.Equal(x, item)
-				assert.True(ok)
-			} else {
-				assert.Equal(0, item)
-				assert.False(ok)
-			}
+	ints = NewOrdered[int]()
+	ints.MergeSortedSlice(seq(1000))
+	ints.BeginBulk()
+	assert.Equal(0, ints.MergeSortedSlice([]int{0, 1}))
+	assert.Equal(0, ints.MergeSortedSlice(seq(2000)))
+	added, removed := ints.ApplyDesired([]int{-1, 1, 2})
+	assert.Equal(0, added)
+	assert.Equal(998, removed)
+	assert.Equal(2, ints.Len())
+	assert.Equal(2000, ints.EndBulk())
+	assert.Equal(append([]int{-1, 0}, seq(2000)...), ints.items())
+	assert.NoError(ints.Validate())
+}
+
+func TestBuildNodes(t *testing.T) {
+	for n := 0; n <= 1000; n++ {
+		tree := NewOrdered[int]()
+		nodes := make([]*node[int], n)
+		for i := range nodes {
+			nodes[i] = newNode(i)
 		}
+		tree.root = tree.buildNodes(nodes)
+		tree.len = n
+		assert.NoError(t, tree.Validate(), "n = %d", n)
+		assertMaxDepth(t, tree)
 	}
 }
 
-func uniq(a []int) int {
-	set := make(map[int]struct{})
-	for _, x := range a {
-		set[x] = struct{}{}
+func TestLLRBTree_CDF(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[string]()
+	assert.Empty(tree.CDF())
+
+	for _, x := range []string{"c", "a", "d", "b"} {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal([]ItemCount[string]{
+		{Item: "a", Count: 1},
+		{Item: "b", Count: 2},
+		{Item: "c", Count: 3},
+		{Item: "d", Count: 4},
+	}, tree.CDF())
+
+	ints := NewOrdered[int]()
+	for _, x := range rnd(1000, 5000) {
+		ints.ReplaceOrInsert(x)
+	}
+	for _, p := range ints.CDF() {
+		rank, ok := ints.rank(p.Item)
+		assert.True(ok)
+		assert.Equal(rank+1, p.Count)
 	}
-	return len(set)
 }
 
-func assertMaxDepth[T any](tb testing.TB, tree *LLRBTree[T]) {
-	tb.Helper()
+func TestLLRBTree_Histogram(t *testing.T) {
+	assert := assert.New(t)
 
-	assert.LessOrEqual(tb, maxDepth(tree.root), int(2*math.Log2(float64(tree.len)+1)))
+	tree := NewOrdered[int]()
+	assert.Equal([]int{0, 0, 0}, tree.Histogram([]int{1, 2}))
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal([]int{100}, tree.Histogram(nil))
+	assert.Equal([]int{9, 10, 30, 40, 11}, tree.Histogram([]int{10, 20, 50, 90}))
+	assert.Equal([]int{0, 100, 0}, tree.Histogram([]int{-5, 200}))
+	assert.Equal([]int{0, 0, 100}, tree.Histogram([]int{0, 1}))
+	assert.Equal([]int{100, 0, 0}, tree.Histogram([]int{101, 1000}))
+	assert.Equal([]int{49, 0, 51}, tree.Histogram([]int{50, 50}))
+}
+
+func TestLLRBTree_FindInRange(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := tree.FindInRange(0, 100, func(int) bool { return true })
+	assert.False(ok)
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	divisibleBy := func(n int) func(int) bool {
+		return func(x int) bool { return x%n == 0 }
+	}
+
+	x, ok := tree.FindInRange(20, 30, divisibleBy(5))
+	assert.Equal(20, x)
+	assert.True(ok)
+
+	x, ok = tree.FindInRange(21, 30, divisibleBy(4))
+	assert.Equal(24, x)
+	assert.True(ok)
+
+	x, ok = tree.FindInRange(21, 30, divisibleBy(29))
+	assert.Equal(29, x)
+	assert.True(ok)
+
+	x, ok = tree.FindInRange(21, 29, divisibleBy(29))
+	assert.Zero(x)
+	assert.False(ok)
+
+	visited := 0
+	tree.FindInRange(1, 101, func(x int) bool {
+		visited++
+		return x == 3
+	})
+	assert.Equal(3, visited)
+}
+
+func TestLLRBTree_SumRangeFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	type order struct {
+		id    int
+		price float64
+	}
+	tree := New(func(a, b order) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	price := func(o order) float64 { return o.price }
+	assert.Zero(tree.SumRangeFunc(order{id: 0}, order{id: 100}, price))
+
+	for _, id := range rnd(500, 1000) {
+		tree.ReplaceOrInsert(order{id: id, price: float64(id%13) * 1.5})
+	}
+
+	for i := 0; i < 50; i++ {
+		lo := rand.Intn(1100) - 50
+		hi := lo + rand.Intn(500)
+		want := 0.0
+		tree.Ascend(func(o order) bool {
+			if o.id >= lo && o.id < hi {
+				want += o.price
+			}
+			return true
+		})
+		assert.InDelta(want, tree.SumRangeFunc(order{id: lo}, order{id: hi}, price), 1e-9)
+	}
+	assert.Zero(tree.SumRangeFunc(order{id: 10}, order{id: 10}, price))
+}
+
+func TestLLRBTree_Partition(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Nil(tree.Partition(0))
+	assert.Equal([][]int{{}, {}}, tree.Partition(2))
+
+	a := seq(10)
+	for _, x := range shuffle(a) {
+		tree.ReplaceOrInsert(x)
+	}
+	slices.Sort(a)
+
+	assert.Equal([][]int{{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}, tree.Partition(1))
+	assert.Equal([][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9, 10}}, tree.Partition(3))
+
+	small := NewOrdered[int]()
+	small.MergeSortedSlice([]int{1, 2, 3})
+	assert.Equal([][]int{{}, {1}, {}, {2}, {3}}, small.Partition(5))
+
+	for n := 1; n <= 12; n++ {
+		buckets := tree.Partition(n)
+		assert.Len(buckets, n)
+		var concat []int
+		for _, b := range buckets {
+			assert.LessOrEqual(len(b), 10/n+1)
+			assert.GreaterOrEqual(len(b), 10/n)
+			concat = append(concat, b...)
+		}
+		assert.Equal(a, concat)
+	}
+}
+
+func TestLLRBTree_RankSelect(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Zero(tree.Rank(1))
+	_, ok := tree.Select(0)
+	assert.False(ok)
+
+	for i := 0; i < 20; i++ {
+		for _, x := range rnd(200, 1000) {
+			tree.ReplaceOrInsert(x)
+		}
+		for _, x := range rnd(100, 1000) {
+			tree.Delete(x)
+		}
+		assertSizes(t, tree)
+
+		items := tree.items()
+		for k, item := range items {
+			x, ok := tree.Select(k)
+			assert.True(ok)
+			assert.Equal(item, x)
+			assert.Equal(k, tree.Rank(item))
+		}
+		for _, x := range rnd(100, 1100) {
+			want, _ := slices.BinarySearch(items, x)
+			assert.Equal(want, tree.Rank(x))
+		}
+		_, ok = tree.Select(-1)
+		assert.False(ok)
+		_, ok = tree.Select(len(items))
+		assert.False(ok)
+	}
+
+	tree = NewOrdered[int]()
+	for _, x := range shuffle(seq(101)) {
+		tree.ReplaceOrInsert(x)
+	}
+	median, _ := tree.Select(tree.Len() / 2)
+	assert.Equal(51, median)
+	assert.Equal(50, tree.Rank(median))
+}
+
+func TestLLRBTree_CountRange(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Zero(tree.CountRange(0, 100))
+	assert.Zero(tree.CountLess(1))
+	assert.Zero(tree.CountGreaterOrEqual(1))
+
+	for _, x := range rnd(2000, 5000) {
+		tree.ReplaceOrInsert(x)
+	}
+	count := func(lo, hi int) int {
+		n := 0
+		tree.AscendRange(lo, hi, func(int) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	for i := 0; i < 200; i++ {
+		lo := rand.Intn(6000) - 500
+		hi := lo + rand.Intn(2000)
+		assert.Equal(count(lo, hi), tree.CountRange(lo, hi))
+		assert.Equal(count(math.MinInt, lo), tree.CountLess(lo))
+		assert.Equal(count(lo, math.MaxInt), tree.CountGreaterOrEqual(lo))
+		assert.Equal(tree.Len(), tree.CountLess(lo)+tree.CountGreaterOrEqual(lo))
+	}
+	assert.Zero(tree.CountRange(100, 100))
+	assert.Zero(tree.CountRange(200, 100))
+	assert.Equal(tree.Len(), tree.CountRange(-1, 5000))
+}
+
+func TestLLRBTree_RankOf(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Zero(tree.RankOf(1))
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x * 10)
+	}
+	assert.Zero(tree.RankOf(-5))
+	assert.Zero(tree.RankOf(10))
+	assert.Equal(1, tree.RankOf(11))
+	assert.Equal(4, tree.RankOf(45))
+	assert.Equal(4, tree.RankOf(50))
+	assert.Equal(99, tree.RankOf(1000))
+	assert.Equal(100, tree.RankOf(1001))
+	assert.Equal(100, tree.RankOf(math.MaxInt))
+
+	items := tree.items()
+	for _, x := range rnd(1000, 1100) {
+		i, _ := slices.BinarySearch(items, x)
+		assert.Equal(i, tree.RankOf(x))
+	}
+}
+
+func TestLLRBTree_PredecessorSuccessor(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := tree.Predecessor(1)
+	assert.False(ok)
+	_, ok = tree.Successor(1)
+	assert.False(ok)
+
+	for _, x := range shuffle(seq(10)) {
+		tree.ReplaceOrInsert(x * 10)
+	}
+	_, ok = tree.Predecessor(10)
+	assert.False(ok)
+	_, ok = tree.Successor(100)
+	assert.False(ok)
+
+	x, ok := tree.Predecessor(11)
+	assert.True(ok)
+	assert.Equal(10, x)
+	x, _ = tree.Predecessor(50)
+	assert.Equal(40, x)
+	x, _ = tree.Predecessor(1000)
+	assert.Equal(100, x)
+	x, _ = tree.Successor(50)
+	assert.Equal(60, x)
+	x, _ = tree.Successor(55)
+	assert.Equal(60, x)
+	x, _ = tree.Successor(-5)
+	assert.Equal(10, x)
+
+	items := tree.items()
+	for i, item := range items {
+		if i > 0 {
+			x, _ := tree.Predecessor(item)
+			assert.Equal(items[i-1], x)
+		}
+		if i+1 < len(items) {
+			x, _ := tree.Successor(item)
+			assert.Equal(items[i+1], x)
+		}
+	}
+}
+
+func TestLLRBTree_PercentileOf(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := tree.PercentileOf(1)
+	assert.False(ok)
+
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		tree.ReplaceOrInsert(x)
+	}
+	items := tree.items()
+	for i, x := range items {
+		p, ok := tree.PercentileOf(x)
+		assert.True(ok)
+		assert.InDelta(float64(i)*100/float64(len(items)), p, 1e-9)
+	}
+
+	tree = NewOrdered[int]()
+	for _, x := range []int{10, 20, 30, 40} {
+		tree.ReplaceOrInsert(x)
+	}
+	p, ok := tree.PercentileOf(10)
+	assert.Equal(0.0, p)
+	assert.True(ok)
+	p, ok = tree.PercentileOf(30)
+	assert.Equal(50.0, p)
+	assert.True(ok)
+	p, ok = tree.PercentileOf(25)
+	assert.Zero(p)
+	assert.False(ok)
+}
+
+func TestLLRBTree_Items(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Empty(slices.Collect(tree.Items()))
+
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		tree.ReplaceOrInsert(x)
+	}
+	items := slices.Collect(tree.Items())
+	assert.Len(items, uniq(a))
+	assert.True(slices.IsSorted(items))
+
+	var collect []int
+	for x := range tree.Items() {
+		if len(collect) == 10 {
+			break
+		}
+		collect = append(collect, x)
+	}
+	assert.Equal(items[:10], collect)
+
+	small := NewOrdered[int]()
+	small.MergeSortedSlice(seq(100))
+	assert.Zero(testing.AllocsPerRun(10, func() {
+		for range small.Items() {
+		}
+	}))
+}
+
+func TestLLRBTree_AllBackward(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Empty(slices.Collect(tree.All()))
+	assert.Empty(slices.Collect(tree.Backward()))
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal(seq(100), slices.Collect(tree.All()))
+	backward := slices.Collect(tree.Backward())
+	slices.Reverse(backward)
+	assert.Equal(seq(100), backward)
+
+	var a []int
+	for x := range tree.All() {
+		if x > 5 {
+			break
+		}
+		a = append(a, x)
+	}
+	assert.Equal(seq(5), a)
+
+	a = a[:0]
+	for x := range tree.Backward() {
+		a = append(a, x)
+		if len(a) == 3 {
+			break
+		}
+	}
+	assert.Equal([]int{100, 99, 98}, a)
+}
+
+func TestLLRBTree_limit(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range shuffle(seq(10)) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	collect := func(walk func(int, IterFunc[int]), n int) []int {
+		var a []int
+		walk(n, func(x int) bool {
+			a = append(a, x)
+			return true
+		})
+		return a
+	}
+	assert.Equal([]int{1, 2, 3}, collect(tree.AscendLimit, 3))
+	assert.Equal([]int{10, 9, 8}, collect(tree.DescendLimit, 3))
+	assert.Equal(seq(10), collect(tree.AscendLimit, 10))
+	assert.Equal(seq(10), collect(tree.AscendLimit, 100))
+	assert.Len(collect(tree.DescendLimit, 100), 10)
+	assert.Empty(collect(tree.AscendLimit, 0))
+	assert.Empty(collect(tree.DescendLimit, -1))
+
+	var a []int
+	tree.AscendLimit(5, func(x int) bool {
+		a = append(a, x)
+		return x < 2
+	})
+	assert.Equal([]int{1, 2}, a)
+}
+
+func TestLLRBTree_DescendIndexed(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	tree.DescendIndexed(func(int, int) bool {
+		assert.Fail("unexpected item")
+		return true
+	})
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x * 10)
+	}
+	n := 0
+	tree.DescendIndexed(func(i, x int) bool {
+		assert.Equal(n, i)
+		assert.Equal((100-i)*10, x)
+		n++
+		return true
+	})
+	assert.Equal(100, n)
+
+	var idx []int
+	tree.DescendIndexed(func(i, x int) bool {
+		idx = append(idx, i)
+		return x > 980
+	})
+	assert.Equal([]int{0, 1, 2}, idx)
+}
+
+func TestLLRBTree_AscendUntilStop(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	x, stopped := tree.AscendUntilStop(func(int) bool { return false })
+	assert.Zero(x)
+	assert.False(stopped)
+
+	for _, x := range shuffle(seq(10)) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	n := 0
+	x, stopped = tree.AscendUntilStop(func(int) bool {
+		n++
+		return true
+	})
+	assert.Zero(x)
+	assert.False(stopped)
+	assert.Equal(10, n)
+
+	x, stopped = tree.AscendUntilStop(func(x int) bool { return x*x < 30 })
+	assert.Equal(6, x)
+	assert.True(stopped)
+
+	x, stopped = tree.AscendUntilStop(func(int) bool { return false })
+	assert.Equal(1, x)
+	assert.True(stopped)
+}
+
+func TestLLRBTree_AscendCtx(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range seq(10000) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	var got []int
+	assert.NoError(tree.AscendCtx(context.Background(), func(x int) bool {
+		got = append(got, x)
+		return true
+	}))
+	assert.Equal(seq(10000), got)
+
+	got = got[:0]
+	assert.NoError(tree.AscendCtx(context.Background(), func(x int) bool {
+		got = append(got, x)
+		return x < 10
+	}))
+	assert.Equal(seq(10), got)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got = got[:0]
+	err := tree.AscendCtx(ctx, func(x int) bool {
+		got = append(got, x)
+		if x == 1000 {
+			cancel()
+		}
+		return true
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.GreaterOrEqual(len(got), 1000)
+	assert.Less(len(got), 1000+ctxCheckInterval)
+	assert.Equal(seq(len(got)), got)
+
+	got = got[:0]
+	err = tree.AscendCtx(ctx, func(x int) bool {
+		got = append(got, x)
+		return true
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Empty(got)
+}
+
+func TestLLRBTree_AscendBuffered(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	tree.AscendBuffered(4, func(int) bool {
+		assert.Fail("unexpected item")
+		return true
+	})
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	for _, size := range []int{1, 3, 10, 100, 1000} {
+		var collect []int
+		tree.AscendBuffered(size, func(x int) bool {
+			collect = append(collect, x)
+			return true
+		})
+		assert.Equal(seq(100), collect)
+
+		collect = collect[:0]
+		tree.AscendBuffered(size, func(x int) bool {
+			collect = append(collect, x)
+			return x < 42
+		})
+		assert.Equal(seq(42), collect)
+	}
+
+	assert.Panics(func() {
+		tree.AscendBuffered(0, func(int) bool { return true })
+	})
+}
+
+func TestLLRBTree_WeightedSample(t *testing.T) {
+	assert := assert.New(t)
+
+	rng := rand.New(rand.NewSource(42))
+	weight := func(x int) float64 { return float64(x) }
+
+	tree := NewOrdered[int]()
+	_, ok := tree.WeightedSample(weight, rng)
+	assert.False(ok)
+
+	tree.ReplaceOrInsert(0)
+	tree.ReplaceOrInsert(-3)
+	_, ok = tree.WeightedSample(weight, rng)
+	assert.False(ok)
+
+	for _, x := range []int{1, 2, 3, 4} {
+		tree.ReplaceOrInsert(x)
+	}
+	const trials = 100000
+	counts := make(map[int]int)
+	for i := 0; i < trials; i++ {
+		x, ok := tree.WeightedSample(weight, rng)
+		assert.True(ok)
+		counts[x]++
+	}
+	assert.Len(counts, 4)
+	for _, x := range []int{1, 2, 3, 4} {
+		assert.InDelta(float64(x)/10, float64(counts[x])/trials, 0.01)
+	}
+
+	a := make(map[int]int)
+	b := make(map[int]int)
+	r1, r2 := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+	for i := 0; i < 100; i++ {
+		x, _ := tree.WeightedSample(weight, r1)
+		y, _ := tree.WeightedSample(weight, r2)
+		a[x]++
+		b[y]++
+	}
+	assert.Equal(a, b)
+}
+
+func TestLLRBTree_AscendShuffled(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range seq(100) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	shuffled := func(seed int64) []int {
+		var a []int
+		tree.AscendShuffled(rand.New(rand.NewSource(seed)), func(x int) bool {
+			a = append(a, x)
+			return true
+		})
+		return a
+	}
+	a := shuffled(42)
+	assert.Equal(a, shuffled(42))
+	assert.NotEqual(seq(100), a)
+	assert.NotEqual(a, shuffled(7))
+	assert.ElementsMatch(seq(100), a)
+
+	var b []int
+	tree.AscendShuffled(rand.New(rand.NewSource(42)), func(x int) bool {
+		b = append(b, x)
+		return len(b) < 10
+	})
+	assert.Equal(a[:10], b)
+}
+
+func TestLLRBTree_AscendStride(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range shuffle(seq(10)) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	stride := func(k int) []int {
+		var collect []int
+		tree.AscendStride(k, func(x int) bool {
+			collect = append(collect, x)
+			return true
+		})
+		return collect
+	}
+	assert.Equal(seq(10), stride(1))
+	assert.Equal([]int{1, 3, 5, 7, 9}, stride(2))
+	assert.Equal([]int{1, 4, 7, 10}, stride(3))
+	assert.Equal([]int{1, 10}, stride(9))
+	assert.Equal([]int{1}, stride(10))
+	assert.Equal([]int{1}, stride(100))
+
+	var collect []int
+	tree.AscendStride(2, func(x int) bool {
+		collect = append(collect, x)
+		return x < 5
+	})
+	assert.Equal([]int{1, 3, 5}, collect)
+
+	assert.Panics(func() {
+		tree.AscendStride(0, func(int) bool { return true })
+	})
+}
+
+func TestLLRBTree_Swap(t *testing.T) {
+	assert := assert.New(t)
+
+	t1 := NewOrdered[int]()
+	for _, x := range []int{1, 2, 3} {
+		t1.ReplaceOrInsert(x)
+	}
+	t2 := New(func(a, b int) int {
+		return b - a
+	})
+	for _, x := range []int{10, 20} {
+		t2.ReplaceOrInsert(x)
+	}
+
+	t1.Swap(t2)
+	assert.Equal(2, t1.Len())
+	assert.Equal(3, t2.Len())
+	assert.Equal([]int{20, 10}, t1.items())
+	assert.Equal([]int{1, 2, 3}, t2.items())
+
+	t1.ReplaceOrInsert(15)
+	t2.ReplaceOrInsert(0)
+	assert.Equal([]int{20, 15, 10}, t1.items())
+	assert.Equal([]int{0, 1, 2, 3}, t2.items())
+	assert.NoError(t1.Validate())
+	assert.NoError(t2.Validate())
+}
+
+func TestLLRBTree_NextPrev(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := tree.Next(0)
+	assert.False(ok)
+	_, ok = tree.Prev(0)
+	assert.False(ok)
+
+	for _, x := range shuffle([]int{10, 20, 30, 40, 50}) {
+		tree.ReplaceOrInsert(x)
+	}
+	for k := 0; k < 4; k++ {
+		x, ok := tree.Next(k)
+		assert.Equal((k+2)*10, x)
+		assert.True(ok)
+	}
+	for k := 1; k < 5; k++ {
+		x, ok := tree.Prev(k)
+		assert.Equal(k*10, x)
+		assert.True(ok)
+	}
+
+	x, ok := tree.Next(tree.Len() - 1)
+	assert.Zero(x)
+	assert.False(ok)
+	x, ok = tree.Prev(0)
+	assert.Zero(x)
+	assert.False(ok)
+	_, ok = tree.Next(100)
+	assert.False(ok)
+	_, ok = tree.Prev(-100)
+	assert.False(ok)
+}
+
+func TestLLRBTree_Slice(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Empty(tree.Slice(0, 10))
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	all := seq(100)
+	for i := 0; i < 50; i++ {
+		from := rand.Intn(100)
+		to := from + rand.Intn(100-from+1)
+		assert.Equal(all[from:to], tree.Slice(from, to))
+	}
+	assert.Equal(all, tree.Slice(0, 100))
+	assert.Equal([]int{1, 2, 3}, tree.Slice(-10, 3))
+	assert.Equal([]int{99, 100}, tree.Slice(98, 1000))
+	assert.Empty(tree.Slice(50, 50))
+	assert.Empty(tree.Slice(60, 50))
+	assert.Empty(tree.Slice(100, 110))
+}
+
+func TestLLRBTree_Around(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Empty(tree.Around(5, 3))
+
+	for _, x := range shuffle([]int{10, 20, 30, 40, 50, 60, 70, 80, 90}) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	assert.Equal([]int{40, 50, 60}, tree.Around(50, 3))
+	assert.Equal([]int{30, 40, 50, 60}, tree.Around(50, 4))
+	assert.Equal([]int{40, 50}, tree.Around(45, 2))
+	assert.Equal([]int{30, 40, 50, 60}, tree.Around(45, 4))
+	assert.Equal([]int{10, 20, 30}, tree.Around(10, 3))
+	assert.Equal([]int{10, 20, 30}, tree.Around(-5, 3))
+	assert.Equal([]int{70, 80, 90}, tree.Around(90, 3))
+	assert.Equal([]int{70, 80, 90}, tree.Around(100, 3))
+	assert.Equal([]int{10, 20, 30, 40, 50, 60, 70, 80, 90}, tree.Around(50, 100))
+	assert.Nil(tree.Around(50, 0))
+}
+
+func TestLLRBTree_Checksum(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := func(x int) uint64 { return uint64(x) }
+	t1, t2 := NewOrdered[int](), NewOrdered[int]()
+	assert.Equal(t1.Checksum(hash), t2.Checksum(hash))
+
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		t1.ReplaceOrInsert(x)
+	}
+	for _, x := range shuffle(a) {
+		t2.ReplaceOrInsert(x)
+	}
+	sum := t1.Checksum(hash)
+	assert.Equal(sum, t2.Checksum(hash))
+	assert.NotEqual(NewOrdered[int]().Checksum(hash), sum)
+
+	x, _ := t2.Max()
+	t2.ReplaceOrInsert(x + 1)
+	assert.NotEqual(sum, t2.Checksum(hash))
+	t2.Delete(x + 1)
+	assert.Equal(sum, t2.Checksum(hash))
+	t2.DeleteMin()
+	assert.NotEqual(sum, t2.Checksum(hash))
+
+	type kv struct{ k, v int }
+	byKey := func(a, b kv) int { return cmp.Compare(a.k, b.k) }
+	kvHash := func(x kv) uint64 { return uint64(x.k)<<32 | uint64(x.v) }
+	t3, t4 := New(byKey), New(byKey)
+	for i := 0; i < 10; i++ {
+		t3.ReplaceOrInsert(kv{i, i})
+		t4.ReplaceOrInsert(kv{i, i})
+	}
+	assert.Equal(t3.Checksum(kvHash), t4.Checksum(kvHash))
+	t4.ReplaceOrInsert(kv{5, 6})
+	assert.NotEqual(t3.Checksum(kvHash), t4.Checksum(kvHash))
+
+	// The checksum depends on the order of the hashes.
+	t5, t6 := New(byKey), New(byKey)
+	t5.ReplaceOrInsert(kv{1, 1})
+	t5.ReplaceOrInsert(kv{2, 2})
+	t6.ReplaceOrInsert(kv{1, 2})
+	t6.ReplaceOrInsert(kv{2, 1})
+	vHash := func(x kv) uint64 { return uint64(x.v) }
+	assert.NotEqual(t5.Checksum(vHash), t6.Checksum(vHash))
+}
+
+func TestLLRBTree_ForEachPostOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	var visited []int
+	visit := func(x int) {
+		visited = append(visited, x)
+	}
+	tree := NewOrdered[int]()
+	tree.ForEachPostOrder(visit)
+	assert.Empty(visited)
+
+	for _, x := range []int{1, 2, 3, 4, 5} {
+		tree.ReplaceOrInsert(x)
+	}
+	tree.ForEachPostOrder(visit)
+	assert.Equal([]int{1, 3, 2, 5, 4}, visited)
+
+	tree = FromLevelOrder(cmp.Compare[int], []int{4, 2, 6, 1, 3, 5, 7})
+	visited = visited[:0]
+	tree.ForEachPostOrder(visit)
+	assert.Equal([]int{1, 3, 2, 5, 7, 6, 4}, visited)
+}
+
+func TestLLRBTree_LevelOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Empty(tree.LevelOrder())
+	assert.Equal(0, FromLevelOrder(cmp.Compare[int], nil).Len())
+
+	for _, x := range []int{1, 2, 3, 4, 5} {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal([]int{4, 2, 5, 1, 3}, tree.LevelOrder())
+
+	for _, x := range rnd(1000, 5000) {
+		tree.ReplaceOrInsert(x)
+	}
+	for _, x := range rnd(500, 5000) {
+		tree.Delete(x)
+	}
+
+	levels := tree.LevelOrder()
+	assert.Len(levels, tree.Len())
+
+	tree2 := FromLevelOrder(cmp.Compare[int], levels)
+	assert.NoError(tree2.Validate())
+	assert.Equal(tree.Len(), tree2.Len())
+	assert.Equal(tree.items(), tree2.items())
+	assert.Equal(levels, tree2.LevelOrder())
+	assert.True(sameShape(tree.root, tree2.root))
+
+	shuffled := slices.Clone(levels)
+	shuffle(shuffled)
+	tree3 := FromLevelOrder(cmp.Compare[int], shuffled)
+	assert.NoError(tree3.Validate())
+	assert.Equal(tree.items(), tree3.items())
+	assertMaxDepth(t, tree3)
+}
+
+func TestLLRBTree_FloorCeil(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, _, hasFloor, hasCeil := tree.FloorCeil(1)
+	assert.False(hasFloor)
+	assert.False(hasCeil)
+
+	for _, x := range shuffle([]int{10, 20, 30, 40, 50}) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	floor, ceil, hasFloor, hasCeil := tree.FloorCeil(5)
+	assert.Zero(floor)
+	assert.Equal(10, ceil)
+	assert.False(hasFloor)
+	assert.True(hasCeil)
+
+	floor, ceil, hasFloor, hasCeil = tree.FloorCeil(55)
+	assert.Equal(50, floor)
+	assert.Zero(ceil)
+	assert.True(hasFloor)
+	assert.False(hasCeil)
+
+	floor, ceil, hasFloor, hasCeil = tree.FloorCeil(30)
+	assert.Equal(30, floor)
+	assert.Equal(30, ceil)
+	assert.True(hasFloor)
+	assert.True(hasCeil)
+
+	for x := 11; x < 50; x++ {
+		if x%10 == 0 {
+			continue
+		}
+		floor, ceil, hasFloor, hasCeil = tree.FloorCeil(x)
+		assert.Equal(x/10*10, floor)
+		assert.Equal(x/10*10+10, ceil)
+		assert.True(hasFloor)
+		assert.True(hasCeil)
+	}
+}
+
+func TestClamp(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := Clamp(tree, 5)
+	assert.False(ok)
+
+	for _, x := range []int{10, 20, 40} {
+		tree.ReplaceOrInsert(x)
+	}
+	for _, c := range []struct{ in, want int }{
+		{-100, 10}, {10, 10}, {13, 10}, {16, 20}, {15, 20},
+		{20, 20}, {29, 20}, {31, 40}, {30, 40}, {100, 40},
+	} {
+		x, ok := Clamp(tree, c.in)
+		assert.True(ok)
+		assert.Equal(c.want, x, "clamp %d", c.in)
+	}
+
+	floats := NewOrdered[float64]()
+	floats.ReplaceOrInsert(0.5)
+	floats.ReplaceOrInsert(1.5)
+	f, _ := Clamp(floats, 0.9)
+	assert.Equal(0.5, f)
+	f, _ = Clamp(floats, 1.1)
+	assert.Equal(1.5, f)
+
+	small := NewOrdered[int8]()
+	small.ReplaceOrInsert(math.MinInt8)
+	small.ReplaceOrInsert(math.MaxInt8)
+	b, _ := Clamp(small, 100)
+	assert.Equal(int8(math.MaxInt8), b)
+	b, _ = Clamp(small, -100)
+	assert.Equal(int8(math.MinInt8), b)
+
+	wide := NewOrdered[int]()
+	wide.ReplaceOrInsert(math.MinInt)
+	wide.ReplaceOrInsert(math.MaxInt)
+	x, _ := Clamp(wide, -1)
+	assert.Equal(math.MinInt, x)
+	x, _ = Clamp(wide, 0)
+	assert.Equal(math.MaxInt, x)
+
+	unsigned := NewOrdered[uint8]()
+	unsigned.ReplaceOrInsert(0)
+	unsigned.ReplaceOrInsert(math.MaxUint8)
+	u, _ := Clamp(unsigned, 100)
+	assert.Equal(uint8(0), u)
+	u, _ = Clamp(unsigned, 200)
+	assert.Equal(uint8(math.MaxUint8), u)
+}
+
+func TestLLRBTree_ClampFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	type version struct{ major, minor int }
+	key := func(v version) int64 {
+		return int64(v.major*100 + v.minor)
+	}
+	dist := func(a, b version) int64 {
+		d := key(a) - key(b)
+		if d < 0 {
+			d = -d
+		}
+		return d
+	}
+	tree := New(func(a, b version) int {
+		return cmp.Compare(key(a), key(b))
+	})
+	_, ok := tree.ClampFunc(version{1, 0}, dist)
+	assert.False(ok)
+
+	tree.ReplaceOrInsert(version{1, 0})
+	tree.ReplaceOrInsert(version{1, 10})
+
+	v, ok := tree.ClampFunc(version{1, 3}, dist)
+	assert.Equal(version{1, 0}, v)
+	assert.True(ok)
+	v, _ = tree.ClampFunc(version{1, 7}, dist)
+	assert.Equal(version{1, 10}, v)
+	v, _ = tree.ClampFunc(version{1, 5}, dist)
+	assert.Equal(version{1, 10}, v)
+	v, _ = tree.ClampFunc(version{0, 1}, dist)
+	assert.Equal(version{1, 0}, v)
+	v, _ = tree.ClampFunc(version{3, 0}, dist)
+	assert.Equal(version{1, 10}, v)
+}
+
+func TestLLRBTree_Height(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tree := range []*LLRBTree[int]{
+		NewOrdered[int](),
+		NewOrdered[int](WithHeightTracking()),
+	} {
+		assert.Equal(0, tree.Height())
+		tree.ReplaceOrInsert(1)
+		assert.Equal(1, tree.Height())
+
+		for i, x := range rnd(5000, 10000) {
+			tree.ReplaceOrInsert(x)
+			if i%100 == 0 {
+				assert.Equal(maxDepth(tree.root), tree.Height())
+			}
+		}
+		assert.NoError(tree.Validate())
+		for i, x := range rnd(5000, 10000) {
+			switch i % 3 {
+			case 0:
+				tree.Delete(x)
+			case 1:
+				tree.DeleteMin()
+			case 2:
+				tree.DeleteMax()
+			}
+			if i%100 == 0 {
+				assert.Equal(maxDepth(tree.root), tree.Height())
+			}
+		}
+		assert.NoError(tree.Validate())
+		assert.Equal(maxDepth(tree.root), tree.Height())
+	}
+
+	tree := NewOrdered[int](WithHeightTracking())
+	tree.MergeSortedSlice(seq(1000))
+	assert.Equal(maxDepth(tree.root), tree.Height())
+	tree = FromLevelOrder(cmp.Compare[int], tree.LevelOrder(), WithHeightTracking())
+	assert.Equal(maxDepth(tree.root), tree.Height())
+	assert.NoError(tree.Validate())
+}
+
+func TestLLRBTree_Reconcile(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	toAdd, toRemove := tree.Reconcile(nil)
+	assert.Empty(toAdd)
+	assert.Empty(toRemove)
+
+	toAdd, toRemove = tree.Reconcile([]int{1, 2})
+	assert.Equal([]int{1, 2}, toAdd)
+	assert.Empty(toRemove)
+
+	for _, x := range []int{1, 3, 5, 7} {
+		tree.ReplaceOrInsert(x)
+	}
+	toAdd, toRemove = tree.Reconcile([]int{1, 3, 5, 7})
+	assert.Empty(toAdd)
+	assert.Empty(toRemove)
+
+	toAdd, toRemove = tree.Reconcile([]int{0, 3, 4, 7, 8, 9})
+	assert.Equal([]int{0, 4, 8, 9}, toAdd)
+	assert.Equal([]int{1, 5}, toRemove)
+
+	toAdd, toRemove = tree.Reconcile(nil)
+	assert.Empty(toAdd)
+	assert.Equal([]int{1, 3, 5, 7}, toRemove)
+	assert.Equal(4, tree.Len())
+}
+
+func TestLLRBTree_ApplyDesired(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	added, removed := tree.ApplyDesired([]int{1, 3, 5, 7})
+	assert.Equal(4, added)
+	assert.Equal(0, removed)
+	assert.Equal([]int{1, 3, 5, 7}, tree.items())
+
+	added, removed = tree.ApplyDesired([]int{0, 3, 4, 7, 8, 9})
+	assert.Equal(4, added)
+	assert.Equal(2, removed)
+	assert.Equal([]int{0, 3, 4, 7, 8, 9}, tree.items())
+	assert.NoError(tree.Validate())
+
+	added, removed = tree.ApplyDesired([]int{0, 3, 4, 7, 8, 9})
+	assert.Equal(0, added)
+	assert.Equal(0, removed)
+
+	for i := 0; i < 10; i++ {
+		desired := rnd(500, 1000)
+		slices.Sort(desired)
+		desired = slices.Compact(desired)
+		tree.ApplyDesired(desired)
+		assert.Equal(desired, tree.items())
+		assert.NoError(tree.Validate())
+		assertMaxDepth(t, tree)
+	}
+
+	added, removed = tree.ApplyDesired(nil)
+	assert.Equal(0, added)
+	assert.Positive(removed)
+	assert.Equal(0, tree.Len())
+}
+
+func TestLLRBTree_PageAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	page, next, hasMore := tree.PageAfter(0, 10)
+	assert.Empty(page)
+	assert.Zero(next)
+	assert.False(hasMore)
+
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		tree.ReplaceOrInsert(x)
+	}
+
+	for _, size := range []int{1, 7, 100, 2000} {
+		var all []int
+		cursor, hasMore := -1, true
+		for hasMore {
+			page, cursor, hasMore = tree.PageAfter(cursor, size)
+			assert.LessOrEqual(len(page), size)
+			if hasMore {
+				assert.Len(page, size)
+			}
+			all = append(all, page...)
+		}
+		assert.Equal(tree.items(), all)
+	}
+
+	for _, x := range []int{10, 20, 30, 40, 50} {
+		tree.ReplaceOrInsert(x + 10000)
+	}
+	page, next, hasMore = tree.PageAfter(10020, 2)
+	assert.Equal([]int{10030, 10040}, page)
+	assert.Equal(10040, next)
+	assert.True(hasMore)
+	page, next, hasMore = tree.PageAfter(10035, 2)
+	assert.Equal([]int{10040, 10050}, page)
+	assert.Equal(10050, next)
+	assert.False(hasMore)
+	page, _, hasMore = tree.PageAfter(10050, 2)
+	assert.Empty(page)
+	assert.False(hasMore)
+
+	for _, size := range []int{0, -1} {
+		assert.PanicsWithValue("llrb: non-positive page size", func() {
+			tree.PageAfter(0, size)
+		})
+	}
+}
+
+func TestLLRBTree_Overlaps(t *testing.T) {
+	assert := assert.New(t)
+
+	t1, t2 := NewOrdered[int](), NewOrdered[int]()
+	assert.False(t1.Overlaps(t2))
+
+	for _, x := range []int{1, 3, 5, 7, 9} {
+		t1.ReplaceOrInsert(x)
+	}
+	assert.False(t1.Overlaps(t2))
+	assert.False(t2.Overlaps(t1))
+
+	for _, x := range []int{0, 2, 4, 6, 8, 10} {
+		t2.ReplaceOrInsert(x)
+	}
+	assert.False(t1.Overlaps(t2))
+	assert.False(t2.Overlaps(t1))
+
+	t2.ReplaceOrInsert(9)
+	assert.True(t1.Overlaps(t2))
+	assert.True(t2.Overlaps(t1))
+	assert.True(t1.Overlaps(t1))
+}
+
+func TestUnionWith(t *testing.T) {
+	assert := assert.New(t)
+
+	type tally struct {
+		key   string
+		count int
+	}
+	byKey := func(a, b tally) int {
+		return strings.Compare(a.key, b.key)
+	}
+	sum := func(x, y tally) tally {
+		return tally{x.key, x.count + y.count}
+	}
+
+	a, b := New(byKey), New(byKey)
+	assert.Equal(0, UnionWith(a, b, sum).Len())
+
+	for _, x := range []tally{{"a", 1}, {"c", 3}, {"e", 5}} {
+		a.ReplaceOrInsert(x)
+	}
+	for _, x := range []tally{{"b", 2}, {"d", 4}} {
+		b.ReplaceOrInsert(x)
+	}
+	u := UnionWith(a, b, sum)
+	assert.NoError(u.Validate())
+	assert.Equal([]tally{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 5}}, u.items())
+
+	b.ReplaceOrInsert(tally{"a", 10})
+	b.ReplaceOrInsert(tally{"e", 50})
+	b.ReplaceOrInsert(tally{"f", 6})
+	u = UnionWith(a, b, sum)
+	assert.Equal([]tally{{"a", 11}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 55}, {"f", 6}}, u.items())
+	assert.Equal(3, a.Len())
+	assert.Equal(5, b.Len())
+
+	x, y := NewOrdered[int](), NewOrdered[int]()
+	xs, ys := rnd(1000, 3000), rnd(1000, 3000)
+	for _, v := range xs {
+		x.ReplaceOrInsert(v)
+	}
+	for _, v := range ys {
+		y.ReplaceOrInsert(v)
+	}
+	u2 := UnionWith(x, y, func(v, _ int) int { return v })
+	assert.NoError(u2.Validate())
+	assert.Equal(uniq(append(xs, ys...)), u2.Len())
+	assert.True(slices.IsSorted(u2.items()))
+}
+
+func TestOverlapRatio(t *testing.T) {
+	assert := assert.New(t)
+
+	from := func(items ...int) *LLRBTree[int] {
+		tree := NewOrdered[int]()
+		for _, x := range items {
+			tree.ReplaceOrInsert(x)
+		}
+		return tree
+	}
+	empty := from()
+	assert.Zero(OverlapRatio(empty, empty))
+	assert.Zero(OverlapRatio(empty, from(1, 2)))
+	assert.Zero(OverlapRatio(from(1, 2), empty))
+
+	a := from(seq(10)...)
+	assert.Equal(1.0, OverlapRatio(a, a))
+	assert.Equal(1.0, OverlapRatio(a, from(2, 4, 6)))
+	assert.Equal(1.0, OverlapRatio(from(2, 4, 6), a))
+	assert.Zero(OverlapRatio(a, from(0, 11, 12)))
+	assert.Equal(0.5, OverlapRatio(a, from(9, 10, 11, 12)))
+	assert.Equal(0.25, OverlapRatio(from(1, 3, 5, 7), from(0, 2, 3, 4, 6, 8)))
+}
+
+func TestLLRBTree_EqualsSorted(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.True(tree.EqualsSorted(nil))
+	assert.False(tree.EqualsSorted([]int{1}))
+
+	for _, x := range shuffle(seq(100)) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.True(tree.EqualsSorted(seq(100)))
+	assert.False(tree.EqualsSorted(nil))
+	assert.False(tree.EqualsSorted(seq(99)))
+	assert.False(tree.EqualsSorted(seq(101)))
+
+	a := seq(100)
+	a[50] = 1000
+	assert.False(tree.EqualsSorted(a))
+	a = seq(100)
+	a[99] = 101
+	assert.False(tree.EqualsSorted(a))
+	a = seq(100)
+	a[0] = 0
+	assert.False(tree.EqualsSorted(a))
+}
+
+func TestLLRBTree_ReplaceFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range seq(10) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	isEven := func(x int) bool { return x%2 == 0 }
+	tree.ReplaceFunc(isEven, func(x int) int { return x + 100 })
+	assert.Equal([]int{1, 3, 5, 7, 9, 102, 104, 106, 108, 110}, tree.items())
+	assert.NoError(tree.Validate())
+
+	tree.ReplaceFunc(func(x int) bool { return x > 100 }, func(x int) int { return x - 99 })
+	assert.Equal([]int{1, 3, 5, 7, 9, 11}, tree.items())
+	assert.Equal(6, tree.Len())
+	assert.NoError(tree.Validate())
+
+	tree.ReplaceFunc(func(int) bool { return false }, func(x int) int { return -x })
+	assert.Equal([]int{1, 3, 5, 7, 9, 11}, tree.items())
+
+	tree = NewOrdered[int]()
+	for _, x := range rnd(1000, 10000) {
+		tree.ReplaceOrInsert(x)
+	}
+	tree.ReplaceFunc(isEven, func(x int) int { return -x - 1 })
+	tree.Ascend(func(x int) bool {
+		assert.True(x < 0 || !isEven(x))
+		return true
+	})
+	assert.NoError(tree.Validate())
+	assertMaxDepth(t, tree)
+}
+
+func TestLLRBTree_WouldChange(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.True(tree.WouldChange(1))
+
+	tree.ReplaceOrInsert(1)
+	tree.ReplaceOrInsert(3)
+	assert.False(tree.WouldChange(1))
+	assert.False(tree.WouldChange(3))
+	assert.True(tree.WouldChange(2))
+	assert.Equal(2, tree.Len())
+}
+
+func TestLLRBTree_DepthOf(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, ok := tree.DepthOf(1)
+	assert.False(ok)
+
+	for _, x := range seq(7) {
+		tree.ReplaceOrInsert(x)
+	}
+	for x, want := range map[int]int{4: 0, 2: 1, 6: 1, 1: 2, 3: 2, 5: 2, 7: 2} {
+		depth, ok := tree.DepthOf(x)
+		assert.Equal(want, depth, "depth of %d", x)
+		assert.True(ok)
+	}
+	depth, ok := tree.DepthOf(8)
+	assert.Zero(depth)
+	assert.False(ok)
+
+	for _, x := range rnd(1000, 10000) {
+		tree.ReplaceOrInsert(x)
+	}
+	root, _ := tree.Root()
+	depth, _ = tree.DepthOf(root)
+	assert.Equal(0, depth)
+	tree.Ascend(func(x int) bool {
+		depth, ok := tree.DepthOf(x)
+		assert.True(ok)
+		assert.Less(depth, tree.Height())
+		return true
+	})
+}
+
+func TestLLRBTree_Root(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	x, ok := tree.Root()
+	assert.Zero(x)
+	assert.False(ok)
+
+	for _, x := range seq(7) {
+		tree.ReplaceOrInsert(x)
+	}
+	x, ok = tree.Root()
+	assert.Equal(4, x)
+	assert.True(ok)
+	assert.False(isRed(tree.root))
+
+	for _, x := range rnd(1000, 10000) {
+		tree.ReplaceOrInsert(x)
+	}
+	x, ok = tree.Root()
+	assert.True(ok)
+	assert.True(tree.Has(x))
+	assert.False(isRed(tree.root))
+
+	tree.Clear()
+	_, ok = tree.Root()
+	assert.False(ok)
+}
+
+func TestLLRBTree_MinDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	assert.Equal(0, tree.MinDepth())
+
+	tree.ReplaceOrInsert(1)
+	assert.Equal(1, tree.MinDepth())
+	tree.ReplaceOrInsert(2)
+	assert.Equal(2, tree.MinDepth())
+
+	tree.Clear()
+	for _, x := range seq(7) {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal(3, tree.MinDepth())
+	assert.Equal(3, tree.Height())
+
+	for i := 0; i < 10; i++ {
+		for _, x := range rnd(1000, 10000) {
+			tree.ReplaceOrInsert(x)
+		}
+		for _, x := range rnd(500, 10000) {
+			tree.Delete(x)
+		}
+		assert.LessOrEqual(tree.MinDepth(), tree.Height())
+		assert.LessOrEqual(tree.Height(), 2*tree.MinDepth())
+	}
+}
+
+func TestDetectCollisions(t *testing.T) {
+	assert := assert.New(t)
+
+	caseInsensitive := func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}
+	assert.Empty(DetectCollisions(caseInsensitive, nil))
+	assert.Empty(DetectCollisions(caseInsensitive, []string{"a", "b", "C"}))
+
+	groups := DetectCollisions(caseInsensitive, []string{"b", "A", "c", "a", "B", "d", "b"})
+	assert.Equal([][]string{{"A", "a"}, {"b", "B", "b"}}, groups)
+
+	tree := New(caseInsensitive)
+	for _, x := range []string{"b", "A", "c", "a", "B", "d", "b"} {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal(4, tree.Len())
+}
+
+func TestMaxGap(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	_, _, _, ok := MaxGap(tree)
+	assert.False(ok)
+	tree.ReplaceOrInsert(5)
+	_, _, _, ok = MaxGap(tree)
+	assert.False(ok)
+
+	for _, x := range []int{1, 2, 4, 17, 20, 21, 30} {
+		tree.ReplaceOrInsert(x)
+	}
+	lo, hi, gap, ok := MaxGap(tree)
+	assert.True(ok)
+	assert.Equal(5, lo)
+	assert.Equal(17, hi)
+	assert.Equal(int64(12), gap)
+
+	tree.ReplaceOrInsert(42)
+	lo, hi, gap, _ = MaxGap(tree)
+	assert.Equal(5, lo)
+	assert.Equal(17, hi)
+	assert.Equal(int64(12), gap)
+
+	tree.ReplaceOrInsert(43)
+	tree.Delete(42)
+	lo, hi, gap, _ = MaxGap(tree)
+	assert.Equal(30, lo)
+	assert.Equal(43, hi)
+	assert.Equal(int64(13), gap)
+
+	tree.ReplaceOrInsert(-20)
+	lo, hi, gap, _ = MaxGap(tree)
+	assert.Equal(-20, lo)
+	assert.Equal(1, hi)
+	assert.Equal(int64(21), gap)
+	lo, hi, gap, _ = tree.MaxGapFunc(func(a, b int) int64 { return int64(b - a) })
+	assert.Equal(-20, lo)
+	assert.Equal(1, hi)
+	assert.Equal(int64(21), gap)
+
+	floats := NewOrdered[float64]()
+	for _, x := range []float64{0.5, 1, 3.75, 4} {
+		floats.ReplaceOrInsert(x)
+	}
+	flo, fhi, gap, ok := MaxGap(floats)
+	assert.True(ok)
+	assert.Equal(1.0, flo)
+	assert.Equal(3.75, fhi)
+	assert.Equal(int64(2), gap)
+
+	small := NewOrdered[int8]()
+	for _, x := range []int8{-100, 100, 101} {
+		small.ReplaceOrInsert(x)
+	}
+	blo, bhi, gap, ok := MaxGap(small)
+	assert.True(ok)
+	assert.Equal(int8(-100), blo)
+	assert.Equal(int8(100), bhi)
+	assert.Equal(int64(200), gap)
+
+	wide := NewOrdered[int]()
+	for _, x := range []int{math.MinInt, 0, math.MaxInt} {
+		wide.ReplaceOrInsert(x)
+	}
+	lo, hi, gap, _ = MaxGap(wide)
+	assert.Equal(math.MinInt, lo)
+	assert.Equal(0, hi)
+	assert.Equal(int64(math.MaxInt64), gap)
+	wide.DeleteMin()
+	lo, hi, gap, _ = MaxGap(wide)
+	assert.Equal(0, lo)
+	assert.Equal(math.MaxInt, hi)
+	assert.Equal(int64(math.MaxInt64), gap)
+
+	unsigned := NewOrdered[uint8]()
+	for _, x := range []uint8{0, 10, 255} {
+		unsigned.ReplaceOrInsert(x)
+	}
+	_, _, gap, _ = MaxGap(unsigned)
+	assert.Equal(int64(245), gap)
+
+	words := NewOrdered[string]()
+	for _, w := range []string{"apple", "apricot", "banana", "cherry", "kiwi", "lemon"} {
+		words.ReplaceOrInsert(w)
+	}
+	slo, shi, gap, ok := words.MaxGapFunc(func(a, b string) int64 {
+		return int64(b[0]) - int64(a[0])
+	})
+	assert.True(ok)
+	assert.Equal("cherry", slo)
+	assert.Equal("kiwi", shi)
+	assert.Equal(int64(8), gap)
+}
+
+func TestLocalExtrema(t *testing.T) {
+	assert := assert.New(t)
+
+	type sample struct {
+		ts    int
+		value float64
+	}
+	byTime := func(a, b sample) int {
+		return cmp.Compare(a.ts, b.ts)
+	}
+	value := func(s sample) float64 { return s.value }
+
+	type extremum struct {
+		ts    int
+		isMin bool
+	}
+	var got []extremum
+	collect := func(s sample, isMin bool) bool {
+		got = append(got, extremum{s.ts, isMin})
+		return true
+	}
+
+	tree := New(byTime)
+	LocalExtrema(tree, value, collect)
+	assert.Empty(got)
+
+	tree.ReplaceOrInsert(sample{1, 5})
+	tree.ReplaceOrInsert(sample{2, 9})
+	LocalExtrema(tree, value, collect)
+	assert.Empty(got)
+
+	for i, v := range []float64{1, 4, 2, 2, 6, 3, 3, 3, 5, 0} {
+		tree.ReplaceOrInsert(sample{i + 3, v})
+	}
+	// The values in time order are 5 9 1 4 2 2 6 3 3 3 5 0.
+	LocalExtrema(tree, value, collect)
+	assert.Equal([]extremum{
+		{2, false},
+		{3, true},
+		{4, false},
+		{7, false},
+		{11, false},
+	}, got)
+
+	got = got[:0]
+	LocalExtrema(tree, value, func(s sample, isMin bool) bool {
+		got = append(got, extremum{s.ts, isMin})
+		return len(got) < 2
+	})
+	assert.Len(got, 2)
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range shuffle(seq(5)) {
+		tree.ReplaceOrInsert(x)
+	}
+
+	var items, sums []int
+	Scan(tree, 0, func(acc, x int) int {
+		return acc + x
+	}, func(x, acc int) bool {
+		items = append(items, x)
+		sums = append(sums, acc)
+		return true
+	})
+	assert.Equal([]int{1, 2, 3, 4, 5}, items)
+	assert.Equal([]int{1, 3, 6, 10, 15}, sums)
+
+	sums = sums[:0]
+	Scan(tree, 0, func(acc, x int) int {
+		return acc + x
+	}, func(_, acc int) bool {
+		sums = append(sums, acc)
+		return acc < 6
+	})
+	assert.Equal([]int{1, 3, 6}, sums)
+}
+
+func TestNewLLRBTreeE(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.PanicsWithValue("nil compare", func() {
+		_ = NewLLRBTreeE[string](nil)
+	})
+
+	errBad := errors.New("bad number")
+	tree := NewLLRBTreeE(func(a, b string) (int, error) {
+		x, err := strconv.Atoi(a)
+		if err != nil {
+			return 0, errBad
+		}
+		y, err := strconv.Atoi(b)
+		if err != nil {
+			return 0, errBad
+		}
+		return cmp.Compare(x, y), nil
+	})
+
+	for _, x := range []string{"10", "2", "33", "4", "5"} {
+		_, exist, err := tree.InsertE(x)
+		assert.NoError(err)
+		assert.False(exist)
+	}
+	prev, exist, err := tree.InsertE("02")
+	assert.Equal("2", prev)
+	assert.True(exist)
+	assert.NoError(err)
+
+	prev, exist, err = tree.InsertE("x")
+	assert.Zero(prev)
+	assert.False(exist)
+	assert.ErrorIs(err, errBad)
+	assert.Equal(5, tree.Len())
+	assert.NoError(tree.Validate())
+	assert.Equal([]string{"02", "4", "5", "10", "33"}, tree.items())
+
+	item, ok, err := tree.GetE("33")
+	assert.Equal("33", item)
+	assert.True(ok)
+	assert.NoError(err)
+
+	item, ok, err = tree.GetE("3")
+	assert.Zero(item)
+	assert.False(ok)
+	assert.NoError(err)
+
+	item, ok, err = tree.GetE("?")
+	assert.Zero(item)
+	assert.False(ok)
+	assert.ErrorIs(err, errBad)
+
+	assert.Panics(func() {
+		tree.Has("?")
+	})
+
+	_, _, err = NewOrdered[int]().InsertE(1)
+	assert.NoError(err)
+
+	tree.BeginBulk()
+	_, _, err = tree.InsertE("x")
+	assert.EqualError(err, "llrb: InsertE during bulk insert")
+	_, _, err = tree.InsertE("6")
+	assert.Error(err)
+	assert.Equal(0, tree.EndBulk())
+	assert.Equal([]string{"02", "4", "5", "10", "33"}, tree.items())
+}
+
+func TestNewFromUnsorted(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.PanicsWithValue("nil compare", func() {
+		_ = NewFromUnsorted[int](nil, nil)
+	})
+	assert.Equal(0, NewFromUnsorted(cmp.Compare[int], nil).Len())
+
+	type pair struct{ k, v int }
+	tree := NewFromUnsorted(func(a, b pair) int {
+		return a.k - b.k
+	}, []pair{{3, 0}, {1, 0}, {2, 0}, {3, 1}, {1, 1}})
+	assert.Equal([]pair{{1, 1}, {2, 0}, {3, 1}}, tree.items())
+
+	for _, n := range []int{1, 2, 3, 10, 100, 1000, 10000} {
+		a := rnd(n, 2*n)
+		tree := NewFromUnsorted(cmp.Compare[int], a)
+		assert.Equal(uniq(a), tree.Len())
+		assert.NoError(tree.Validate())
+		items := tree.items()
+		assert.True(slices.IsSorted(items))
+		for _, x := range a {
+			assert.True(tree.Has(x))
+		}
+		assertMaxDepth(t, tree)
+		assert.LessOrEqual(tree.Height(), bits.Len(uint(tree.Len()))+1)
+	}
+}
+
+func TestNewFloatTree(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewFloatTree[float64]()
+	for _, x := range []float64{
+		math.NaN(), 3, math.Inf(1), -1, math.Inf(-1), math.NaN(), 0, 2.5,
+	} {
+		tree.ReplaceOrInsert(x)
+	}
+	assert.Equal(7, tree.Len())
+	assertMaxDepth(t, tree)
+
+	var collect []float64
+	tree.Ascend(func(x float64) bool {
+		collect = append(collect, x)
+		return true
+	})
+	assert.Len(collect, 7)
+	assert.Equal([]float64{math.Inf(-1), -1, 0, 2.5, 3, math.Inf(1)}, collect[:6])
+	assert.True(math.IsNaN(collect[6]))
+
+	x, ok := tree.Get(math.NaN())
+	assert.True(ok)
+	assert.True(math.IsNaN(x))
+	assert.True(tree.Has(math.Inf(1)))
+	assert.True(tree.Has(math.Inf(-1)))
+	assert.True(tree.Has(math.Copysign(0, -1)))
+	assert.False(tree.Has(1))
+
+	_, ok = tree.Delete(math.NaN())
+	assert.True(ok)
+	assert.False(tree.Has(math.NaN()))
+	x, _ = tree.DeleteMax()
+	assert.Equal(math.Inf(1), x)
+}
+
+func BenchmarkLLRBTree_insert_random(b *testing.B) {
+	const L = 50000
+	assert := assert.New(b)
+
+	a := seq(L)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		shuffle(a)
+		runtime.GC()
+		b.StartTimer()
+
+		t := NewOrdered[int]()
+		for _, x := range a {
+			prev, exist := t.ReplaceOrInsert(x)
+
+			assert.Equal(0, prev)
+			assert.False(exist)
+		}
+		assert.Equal(L, t.Len())
+	}
+}
+
+func BenchmarkLLRBTree_insert_ascending(b *testing.B) {
+	const L = 50000
+	assert := assert.New(b)
+
+	a := seq(L)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		b.StartTimer()
+
+		t := NewOrdered[int]()
+		for _, x := range a {
+			prev, exist := t.ReplaceOrInsert(x)
+
+			assert.Equal(0, prev)
+			assert.False(exist)
+		}
+		assert.Equal(L, t.Len())
+	}
+}
+
+func BenchmarkLLRBTree_MergeSortedSlice(b *testing.B) {
+	const L = 50000
+
+	base := seq(L)
+	for i := range base {
+		base[i] *= 2
+	}
+	items := seq(L)
+
+	b.Run("merge", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			t := NewOrdered[int]()
+			t.MergeSortedSlice(base)
+			b.StartTimer()
+
+			t.MergeSortedSlice(items)
+		}
+	})
+
+	b.Run("insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			t := NewOrdered[int]()
+			t.MergeSortedSlice(base)
+			b.StartTimer()
+
+			for _, x := range items {
+				t.ReplaceOrInsert(x)
+			}
+		}
+	})
+}
+
+func BenchmarkLLRBTree_ascend(b *testing.B) {
+	const L = 50000
+
+	t := NewOrdered[int]()
+	t.MergeSortedSlice(seq(L))
+	sum := 0
+	iter := func(x int) bool {
+		sum += x
+		return true
+	}
+
+	b.Run("plain", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t.Ascend(iter)
+		}
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t.AscendBuffered(256, iter)
+		}
+	})
+}
+
+func BenchmarkNewFromUnsorted(b *testing.B) {
+	const L = 50000
+	a := rnd(L, 2*L)
+
+	b.Run("build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewFromUnsorted(cmp.Compare[int], a)
+		}
+	})
+
+	b.Run("insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t := NewOrdered[int]()
+			for _, x := range a {
+				t.ReplaceOrInsert(x)
+			}
+		}
+	})
+}
+
+func BenchmarkLLRBTree_get_random(b *testing.B) {
+	const L = 50000
+	assert := assert.New(b)
+
+	a := seq(L)
+	getOps := seq(2 * L)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		shuffle(a)
+		shuffle(getOps)
+		t := NewOrdered[int]()
+		for _, x := range a {
+			_, _ = t.ReplaceOrInsert(x)
+		}
+		runtime.GC()
+		b.StartTimer()
+
+		for _, x := range getOps {
+			item, ok := t.Get(x)
+			if x <= L {
+				assert.Equal(x, item)
+				assert.True(ok)
+			} else {
+				assert.Equal(0, item)
+				assert.False(ok)
+			}
+		}
+	}
+}
+
+func BenchmarkLLRBTree_get_ascending(b *testing.B) {
+	const L = 50000
+	assert := assert.New(b)
+
+	a := seq(L)
+	getOps := seq(2 * L)
+
+	t := NewOrdered[int]()
+	for _, x := range a {
+		_, _ = t.ReplaceOrInsert(x)
+	}
+	runtime.GC()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, x := range getOps {
+			item, ok := t.Get(x)
+			if x <= L {
+				assert.Equal(x, item)
+				assert.True(ok)
+			} else {
+				assert.Equal(0, item)
+				assert.False(ok)
+			}
+		}
+	}
+}
+
+func uniq(a []int) int {
+	set := make(map[int]struct{})
+	for _, x := range a {
+		set[x] = struct{}{}
+	}
+	return len(set)
+}
+
+func assertMaxDepth[T any](tb testing.TB, tree *LLRBTree[T]) {
+	tb.Helper()
+
+	assert.LessOrEqual(tb, maxDepth(tree.root), int(2*math.Log2(float64(tree.len)+1)))
+}
+
+func assertSizes[T any](tb testing.TB, tree *LLRBTree[T]) {
+	tb.Helper()
+
+	var check func(h *node[T]) int
+	check = func(h *node[T]) int {
+		if h == nil {
+			return 0
+		}
+		n := 1 + check(h.left) + check(h.right)
+		assert.Equal(tb, n, size(h))
+		return n
+	}
+	assert.Equal(tb, tree.len, check(tree.root))
+}
+
+func sameShape[T comparable](a, b *node[T]) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.item == b.item && a.color() == b.color() &&
+		sameShape(a.left, b.left) && sameShape(a.right, b.right)
 }
 
 func maxDepth[T any](h *node[T]) int {
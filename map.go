@@ -4,13 +4,25 @@
 
 package llrb
 
-import "cmp"
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"iter"
+	"slices"
+)
 
 type entry[K cmp.Ordered, V any] struct {
 	key   K
 	value V
 }
 
+// Pair is a key-value pair of a map.
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
 // LLRBMap represents a left-leaning red-black tree map.
 type LLRBMap[K cmp.Ordered, V any] struct {
 	tr *LLRBTree[*entry[K, V]]
@@ -41,6 +53,51 @@ func (m *LLRBMap[K, V]) Set(key K, value V) (V, bool) {
 	return zero[V](), false
 }
 
+// SetAll sets every key to the value at the same index of values, as a
+// series of Set calls would. It returns an error, leaving the map unchanged,
+// if the slices have different lengths. If the keys are sorted in ascending
+// order without duplicates, they are merged into the map in a single linear
+// pass; otherwise they are set one by one.
+func (m *LLRBMap[K, V]) SetAll(keys []K, values []V) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("llrb: %d keys but %d values", len(keys), len(values))
+	}
+	sorted := true
+	for i := 1; i < len(keys); i++ {
+		if cmp.Compare(keys[i-1], keys[i]) >= 0 {
+			sorted = false
+			break
+		}
+	}
+	if !sorted {
+		for i, key := range keys {
+			m.Set(key, values[i])
+		}
+		return nil
+	}
+
+	entries := make([]*entry[K, V], len(keys))
+	for i, key := range keys {
+		entries[i] = &entry[K, V]{key: key, value: values[i]}
+	}
+	m.tr.MergeSortedSlice(entries)
+	return nil
+}
+
+// ReplaceExisting replaces the value associated with the specified key only
+// if the key already exists in the map. It returns the previous value and
+// true, or the zero value and false, leaving the map unchanged, if the key
+// does not exist.
+func (m *LLRBMap[K, V]) ReplaceExisting(key K, value V) (V, bool) {
+	ent, ok := m.tr.Get(&entry[K, V]{key: key})
+	if !ok {
+		return zero[V](), false
+	}
+	prev := ent.value
+	ent.value = value
+	return prev, true
+}
+
 // Get retrieves the value associated with the specified key from the map.
 // It returns the value and a boolean indicating if the key exists in the map.
 func (m *LLRBMap[K, V]) Get(key K) (V, bool) {
@@ -62,6 +119,52 @@ func (m *LLRBMap[K, V]) Delete(key K) (V, bool) {
 	return zero[V](), false
 }
 
+// PurgeBefore deletes every key-value pair whose key is less than cutoff, by
+// repeatedly deleting the smallest key, and returns the number of pairs
+// removed. It is the usual way to evict expired entries from a map keyed by
+// time.
+func (m *LLRBMap[K, V]) PurgeBefore(cutoff K) int {
+	n := 0
+	for {
+		h := m.tr.minNode()
+		if h == nil || cmp.Compare(h.item.key, cutoff) >= 0 {
+			return n
+		}
+		m.tr.DeleteMin()
+		n++
+	}
+}
+
+// KeepFirst retains only the n pairs with the smallest keys, removing the
+// others by repeatedly deleting the largest key. It empties the map if n is
+// not positive.
+func (m *LLRBMap[K, V]) KeepFirst(n int) {
+	for m.Len() > max(n, 0) {
+		m.tr.DeleteMax()
+	}
+}
+
+// KeepLast retains only the n pairs with the largest keys, removing the
+// others by repeatedly deleting the smallest key. It empties the map if n is
+// not positive.
+func (m *LLRBMap[K, V]) KeepLast(n int) {
+	for m.Len() > max(n, 0) {
+		m.tr.DeleteMin()
+	}
+}
+
+// Rekey moves the value stored under oldKey to newKey. It returns false,
+// leaving the map unchanged, if oldKey doesn't exist or newKey already
+// exists.
+func (m *LLRBMap[K, V]) Rekey(oldKey, newKey K) bool {
+	if !m.Has(oldKey) || m.Has(newKey) {
+		return false
+	}
+	value, _ := m.Delete(oldKey)
+	m.Set(newKey, value)
+	return true
+}
+
 // Range iterates over the key-value pairs in the map in ascending order of the keys.
 // The provided callback function is called for each key-value pair.
 // Iteration stops if the callback function returns false.
@@ -71,6 +174,141 @@ func (m *LLRBMap[K, V]) Range(iter func(key K, value V) bool) {
 	})
 }
 
+// All returns a sequence that yields the key-value pairs in the map in
+// ascending order of the keys, for use with range-over-func loops. The walk
+// stops as soon as the consumer breaks out of the loop.
+func (m *LLRBMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.Range(yield)
+	}
+}
+
+// Backward returns a sequence that yields the key-value pairs in the map in
+// descending order of the keys.
+func (m *LLRBMap[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.tr.Descend(func(ent *entry[K, V]) bool {
+			return yield(ent.key, ent.value)
+		})
+	}
+}
+
+// RangeValuesWhere iterates over the key-value pairs in the map in ascending
+// order of the keys, calling the callback only for the pairs whose value
+// satisfies pred. Iteration stops if the callback function returns false.
+func (m *LLRBMap[K, V]) RangeValuesWhere(pred func(value V) bool, iter func(key K, value V) bool) {
+	m.Range(func(key K, value V) bool {
+		return !pred(value) || iter(key, value)
+	})
+}
+
+// RangeSnapshot iterates over the key-value pairs in the map in ascending
+// order of the keys, like Range, but over a snapshot of the entries taken
+// before the first callback. The callback may therefore safely modify the map;
+// such changes are not observed by the ongoing iteration. Taking the snapshot
+// allocates O(n) memory.
+func (m *LLRBMap[K, V]) RangeSnapshot(iter func(key K, value V) bool) {
+	entries := make([]entry[K, V], 0, m.Len())
+	m.Range(func(key K, value V) bool {
+		entries = append(entries, entry[K, V]{key: key, value: value})
+		return true
+	})
+	for _, ent := range entries {
+		if !iter(ent.key, ent.value) {
+			return
+		}
+	}
+}
+
+// RangeValuePtr iterates over the key-value pairs in the map in ascending
+// order of the keys, passing a pointer to the stored value so that the
+// callback can update it in place without a Set round trip.
+// Iteration stops if the callback function returns false.
+//
+// The pointer aliases the map's storage: it must not be retained after the
+// callback returns, since a later Set or Delete of the key detaches it from
+// the map and writes through it are silently lost.
+func (m *LLRBMap[K, V]) RangeValuePtr(iter func(key K, value *V) bool) {
+	m.tr.Ascend(func(ent *entry[K, V]) bool {
+		return iter(ent.key, &ent.value)
+	})
+}
+
+// ChangedKeys returns, in ascending order, the keys present in both m and
+// other whose values differ according to eq. Keys present in only one of the
+// maps are ignored. It walks both maps in a single linear merge.
+func (m *LLRBMap[K, V]) ChangedKeys(other *LLRBMap[K, V], eq func(a, b V) bool) []K {
+	var changed []K
+	a, b := newCursor(m.tr.root), newCursor(other.tr.root)
+	for {
+		x, okx := a.peek()
+		y, oky := b.peek()
+		if !okx || !oky {
+			return changed
+		}
+		switch cmp.Compare(x.key, y.key) {
+		case -1:
+			a.next()
+		case 1:
+			b.next()
+		default:
+			if !eq(x.value, y.value) {
+				changed = append(changed, x.key)
+			}
+			a.next()
+			b.next()
+		}
+	}
+}
+
+// RangeDelete iterates over the key-value pairs in the map in ascending order
+// of the keys, and deletes those for which the callback returns keep ==
+// false. Iteration stops after the callback returns stop == true, whose keep
+// result still applies. The deletions are collected during the walk and
+// applied once it ends, so the callback must not modify the map itself.
+func (m *LLRBMap[K, V]) RangeDelete(iter func(key K, value V) (keep, stop bool)) {
+	var doomed []*entry[K, V]
+	m.tr.Ascend(func(ent *entry[K, V]) bool {
+		keep, stop := iter(ent.key, ent.value)
+		if !keep {
+			doomed = append(doomed, ent)
+		}
+		return !stop
+	})
+	for _, ent := range doomed {
+		m.tr.Delete(ent)
+	}
+}
+
+// GroupByRange splits the entries of the map into buckets delimited by the
+// given boundaries, which must be sorted in ascending order, and calls iter
+// once per bucket, in order, with the bucket index and its entries in
+// ascending order of the keys, until iter returns false. For n boundaries
+// there are n+1 buckets: bucket 0 holds the keys less than buckets[0],
+// bucket i the keys within [buckets[i-1], buckets[i]), and bucket n the keys
+// greater than or equal to buckets[n-1]. Empty buckets are reported with no
+// entries.
+func (m *LLRBMap[K, V]) GroupByRange(
+	buckets []K,
+	iter func(bucketIndex int, entries []Pair[K, V]) bool,
+) {
+	c := newCursor(m.tr.root)
+	for i := 0; i <= len(buckets); i++ {
+		var entries []Pair[K, V]
+		for {
+			ent, ok := c.peek()
+			if !ok || i < len(buckets) && cmp.Compare(ent.key, buckets[i]) >= 0 {
+				break
+			}
+			entries = append(entries, Pair[K, V]{Key: ent.key, Value: ent.value})
+			c.next()
+		}
+		if !iter(i, entries) {
+			return
+		}
+	}
+}
+
 // Has checks if the map contains the specified key.
 // It returns true if the key exists in the map, false otherwise.
 func (m *LLRBMap[K, V]) Has(key K) bool {
@@ -86,3 +324,297 @@ func (m *LLRBMap[K, V]) Len() int {
 func (m *LLRBMap[K, V]) Clear() {
 	m.tr.Clear()
 }
+
+// RangeByValue iterates over the key-value pairs in the map in ascending
+// order of the values, breaking ties by ascending key order.
+// Iteration stops if the callback function returns false.
+//
+// It is a function rather than a method because it needs ordered values. It
+// sorts a snapshot of the entries, taking O(n log n) time and O(n) memory, so
+// changes made to the map during iteration are not observed.
+func RangeByValue[K, V cmp.Ordered](m *LLRBMap[K, V], iter func(key K, value V) bool) {
+	entries := make([]entry[K, V], 0, m.Len())
+	m.Range(func(key K, value V) bool {
+		entries = append(entries, entry[K, V]{key: key, value: value})
+		return true
+	})
+	slices.SortStableFunc(entries, func(a, b entry[K, V]) int {
+		return cmp.Compare(a.value, b.value)
+	})
+	for _, ent := range entries {
+		if !iter(ent.key, ent.value) {
+			return
+		}
+	}
+}
+
+// TopByValue returns up to n entries of the map with the largest values, in
+// descending order of the values. Entries with equal values are ordered by
+// ascending key. It selects them with a bounded heap in O(m log n) time and
+// O(n) memory for a map of m entries. It returns nil if n is not positive.
+//
+// It is a function rather than a method because it needs ordered values.
+func TopByValue[K, V cmp.Ordered](m *LLRBMap[K, V], n int) []Pair[K, V] {
+	if n <= 0 {
+		return nil
+	}
+	h := make(pairHeap[K, V], 0, min(n, m.Len()))
+	m.Range(func(key K, value V) bool {
+		p := Pair[K, V]{Key: key, Value: value}
+		if len(h) < n {
+			heap.Push(&h, p)
+		} else if h.better(p, h[0]) {
+			h[0] = p
+			heap.Fix(&h, 0)
+		}
+		return true
+	})
+	top := []Pair[K, V](h)
+	slices.SortFunc(top, func(a, b Pair[K, V]) int {
+		if c := cmp.Compare(b.Value, a.Value); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return top
+}
+
+// pairHeap is a min-heap of pairs whose root is the worst pair, that is the
+// one with the smallest value and, among equal values, the largest key.
+type pairHeap[K, V cmp.Ordered] []Pair[K, V]
+
+func (h pairHeap[K, V]) better(a, b Pair[K, V]) bool {
+	if c := cmp.Compare(a.Value, b.Value); c != 0 {
+		return c > 0
+	}
+	return cmp.Less(a.Key, b.Key)
+}
+
+func (h pairHeap[K, V]) Len() int { return len(h) }
+
+func (h pairHeap[K, V]) Less(i, j int) bool { return h.better(h[j], h[i]) }
+
+func (h pairHeap[K, V]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pairHeap[K, V]) Push(x any) { *h = append(*h, x.(Pair[K, V])) }
+
+func (h *pairHeap[K, V]) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Patch computes the key-level difference between two maps with a single
+// linear merge. It returns, in ascending order, the keys only present in to,
+// the keys only present in from, and the keys present in both with
+// different values.
+func Patch[K cmp.Ordered, V comparable](from, to *LLRBMap[K, V]) (added, removed, changed []K) {
+	a, b := newCursor(from.tr.root), newCursor(to.tr.root)
+	for {
+		x, okx := a.peek()
+		y, oky := b.peek()
+		if !okx && !oky {
+			return added, removed, changed
+		}
+		var c int
+		switch {
+		case !oky:
+			c = -1
+		case !okx:
+			c = 1
+		default:
+			c = cmp.Compare(x.key, y.key)
+		}
+
+		switch {
+		case c < 0:
+			removed = append(removed, x.key)
+			a.next()
+		case c > 0:
+			added = append(added, y.key)
+			b.next()
+		default:
+			if x.value != y.value {
+				changed = append(changed, x.key)
+			}
+			a.next()
+			b.next()
+		}
+	}
+}
+
+// ScanDescending walks the map in descending order of the keys, threading an
+// accumulator through the walk. For every entry, step folds it into the
+// accumulator and emit is called with the entry and the running accumulator,
+// until emit returns false. It is handy for suffix sums over time series.
+func ScanDescending[K cmp.Ordered, V, A any](
+	m *LLRBMap[K, V],
+	init A,
+	step func(acc A, key K, value V) A,
+	emit func(key K, value V, acc A) bool,
+) {
+	acc := init
+	m.tr.Descend(func(ent *entry[K, V]) bool {
+		acc = step(acc, ent.key, ent.value)
+		return emit(ent.key, ent.value, acc)
+	})
+}
+
+// WindowSum returns the sum of the values whose keys are within the range
+// [from, to). It visits every entry in the range, so it runs in O(log n + k).
+func WindowSum[K cmp.Ordered, V number](m *LLRBMap[K, V], from, to K) V {
+	var sum V
+	m.tr.AscendRange(&entry[K, V]{key: from}, &entry[K, V]{key: to}, func(ent *entry[K, V]) bool {
+		sum += ent.value
+		return true
+	})
+	return sum
+}
+
+// MovingAverage returns the mean of the values whose keys are within the range
+// [from, to), along with the number of values averaged, or (0, 0) if the range
+// is empty. Like WindowSum, it runs in O(log n + k).
+func MovingAverage[K cmp.Ordered, V number](m *LLRBMap[K, V], from, to K) (float64, int) {
+	var sum float64
+	n := 0
+	m.tr.AscendRange(&entry[K, V]{key: from}, &entry[K, V]{key: to}, func(ent *entry[K, V]) bool {
+		sum += float64(ent.value)
+		n++
+		return true
+	})
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / float64(n), n
+}
+
+// Zip joins two maps on their keys, returning a new map that holds, for every
+// key present in both maps, the pair of their values. It walks both maps in a
+// single linear merge and builds the result in linear time.
+func Zip[K cmp.Ordered, A, B any](a *LLRBMap[K, A], b *LLRBMap[K, B]) *LLRBMap[K, struct {
+	A A
+	B B
+}] {
+	type pair = struct {
+		A A
+		B B
+	}
+	var joined []*entry[K, pair]
+	ca, cb := newCursor(a.tr.root), newCursor(b.tr.root)
+	for {
+		x, okx := ca.peek()
+		y, oky := cb.peek()
+		if !okx || !oky {
+			break
+		}
+		switch cmp.Compare(x.key, y.key) {
+		case -1:
+			ca.next()
+		case 1:
+			cb.next()
+		default:
+			joined = append(joined, &entry[K, pair]{
+				key:   x.key,
+				value: pair{A: x.value, B: y.value},
+			})
+			ca.next()
+			cb.next()
+		}
+	}
+
+	m := NewMap[K, pair]()
+	m.tr.MergeSortedSlice(joined)
+	return m
+}
+
+// LeftJoin iterates over the entries of left in ascending order of the keys,
+// calling iter with every key and value of left, along with the value of
+// right for that key and true, or the zero value and false if right has no
+// such key, until iter returns false. It walks both maps in a single linear
+// merge.
+func LeftJoin[K cmp.Ordered, A, B any](
+	left *LLRBMap[K, A],
+	right *LLRBMap[K, B],
+	iter func(key K, a A, b B, matched bool) bool,
+) {
+	ca, cb := newCursor(left.tr.root), newCursor(right.tr.root)
+	for {
+		x, ok := ca.next()
+		if !ok {
+			return
+		}
+		y, oky := cb.peek()
+		for oky && cmp.Compare(y.key, x.key) < 0 {
+			cb.next()
+			y, oky = cb.peek()
+		}
+		var cont bool
+		if oky && cmp.Compare(y.key, x.key) == 0 {
+			cont = iter(x.key, x.value, y.value, true)
+			cb.next()
+		} else {
+			cont = iter(x.key, x.value, zero[B](), false)
+		}
+		if !cont {
+			return
+		}
+	}
+}
+
+// CountBy returns a map from every distinct item of the slice to the number
+// of times it occurs.
+func CountBy[T cmp.Ordered](items []T) *LLRBMap[T, int] {
+	m := NewMap[T, int]()
+	for _, item := range items {
+		if ent, ok := m.tr.Get(&entry[T, int]{key: item}); ok {
+			ent.value++
+		} else {
+			m.Set(item, 1)
+		}
+	}
+	return m
+}
+
+// PrefixCounts returns a map from every distinct prefix of prefixLen bytes of
+// the keys of m to the number of keys starting with it. Keys shorter than
+// prefixLen count as their own prefix. Since truncating keys preserves their
+// order, equal prefixes are adjacent, and the counts are computed in a single
+// ascending walk and the result built in linear time.
+func PrefixCounts[V any](m *LLRBMap[string, V], prefixLen int) *LLRBMap[string, int] {
+	var counts []*entry[string, int]
+	m.Range(func(key string, _ V) bool {
+		prefix := key[:min(max(prefixLen, 0), len(key))]
+		if n := len(counts); n > 0 && counts[n-1].key == prefix {
+			counts[n-1].value++
+		} else {
+			counts = append(counts, &entry[string, int]{key: prefix, value: 1})
+		}
+		return true
+	})
+
+	res := NewMap[string, int]()
+	res.tr.MergeSortedSlice(counts)
+	return res
+}
+
+// FlattenPairs iterates over a map of sets as a flat sequence of pairs,
+// calling iter with every key and each member of its set, in ascending order
+// of the keys and then of the members, until iter returns false. Keys mapped
+// to a nil or empty set are skipped. It is handy for emitting adjacency lists.
+func FlattenPairs[K cmp.Ordered, V cmp.Ordered](
+	m *LLRBMap[K, *LLRBSet[V]],
+	iter func(key K, member V) bool,
+) {
+	m.Range(func(key K, set *LLRBSet[V]) bool {
+		if set == nil {
+			return true
+		}
+		ok := true
+		set.Range(func(member V) bool {
+			ok = iter(key, member)
+			return ok
+		})
+		return ok
+	})
+}
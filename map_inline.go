@@ -0,0 +1,109 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import "cmp"
+
+// LLRBInlineMap represents a left-leaning red-black tree map that stores its
+// entries by value inside the tree nodes. Compared to LLRBMap, it saves an
+// allocation and a pointer indirection per entry, at the cost of copying the
+// entries around, which pays off for small values.
+type LLRBInlineMap[K cmp.Ordered, V any] struct {
+	tr *LLRBTree[entry[K, V]]
+}
+
+func compareInlineMapEntry[K cmp.Ordered, V any](e1, e2 entry[K, V]) int {
+	return cmp.Compare(e1.key, e2.key)
+}
+
+// NewMapInline creates a new LLRBInlineMap. It only mirrors the core methods
+// of LLRBMap: Set, Get, Delete, Range, RangeSnapshot, RangeValuePtr, Has, Len
+// and Clear. The other methods of LLRBMap and the package functions taking an
+// LLRBMap are not available for it.
+func NewMapInline[K cmp.Ordered, V any]() *LLRBInlineMap[K, V] {
+	return &LLRBInlineMap[K, V]{
+		tr: New[entry[K, V]](compareInlineMapEntry[K, V]),
+	}
+}
+
+// Set inserts or replaces a key-value pair in the map.
+// It returns the previous value associated with the key
+// and a boolean indicating if the key existed.
+func (m *LLRBInlineMap[K, V]) Set(key K, value V) (V, bool) {
+	prev, exist := m.tr.ReplaceOrInsert(entry[K, V]{
+		key:   key,
+		value: value,
+	})
+	return prev.value, exist
+}
+
+// Get retrieves the value associated with the specified key from the map.
+// It returns the value and a boolean indicating if the key exists in the map.
+func (m *LLRBInlineMap[K, V]) Get(key K) (V, bool) {
+	ent, ok := m.tr.Get(entry[K, V]{key: key})
+	return ent.value, ok
+}
+
+// Delete removes the key-value pair with the specified key from the map.
+// It returns the value associated with the key and a boolean indicating
+// if the key existed.
+func (m *LLRBInlineMap[K, V]) Delete(key K) (V, bool) {
+	ent, ok := m.tr.Delete(entry[K, V]{key: key})
+	return ent.value, ok
+}
+
+// Range iterates over the key-value pairs in the map in ascending order of the keys.
+// The provided callback function is called for each key-value pair.
+// Iteration stops if the callback function returns false.
+func (m *LLRBInlineMap[K, V]) Range(iter func(key K, value V) bool) {
+	m.tr.Ascend(func(ent entry[K, V]) bool {
+		return iter(ent.key, ent.value)
+	})
+}
+
+// RangeSnapshot iterates over the key-value pairs in the map in ascending
+// order of the keys, over a snapshot of the entries taken before the first
+// callback, so the callback may safely modify the map. Taking the snapshot
+// allocates O(n) memory.
+func (m *LLRBInlineMap[K, V]) RangeSnapshot(iter func(key K, value V) bool) {
+	for _, ent := range m.tr.items() {
+		if !iter(ent.key, ent.value) {
+			return
+		}
+	}
+}
+
+// RangeValuePtr iterates over the key-value pairs in the map in ascending
+// order of the keys, passing a pointer to the stored value so that the
+// callback can update it in place without a Set round trip.
+// Iteration stops if the callback function returns false.
+//
+// The pointer aliases the tree node holding the entry: it must not be
+// retained after the callback returns, since later changes to the map may
+// move entries between nodes.
+func (m *LLRBInlineMap[K, V]) RangeValuePtr(iter func(key K, value *V) bool) {
+	c := newCursor(m.tr.root)
+	for h := c.nextNode(); h != nil; h = c.nextNode() {
+		if !iter(h.item.key, &h.item.value) {
+			return
+		}
+	}
+}
+
+// Has checks if the map contains the specified key.
+// It returns true if the key exists in the map, false otherwise.
+func (m *LLRBInlineMap[K, V]) Has(key K) bool {
+	return m.tr.Has(entry[K, V]{key: key})
+}
+
+// Len returns the number of key-value pairs in the map.
+func (m *LLRBInlineMap[K, V]) Len() int {
+	return m.tr.Len()
+}
+
+// Clear removes all key-value pairs from the map, resulting in an empty map.
+func (m *LLRBInlineMap[K, V]) Clear() {
+	m.tr.Clear()
+}
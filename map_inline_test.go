@@ -0,0 +1,127 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLRBInlineMap(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMapInline[string, string]()
+
+	prev, exist := m.Set("foo", "bar")
+	assert.Zero(prev)
+	assert.False(exist)
+	assert.Equal(1, m.Len())
+	v, ok := m.Get("foo")
+	assert.Equal("bar", v)
+	assert.True(ok)
+	v, ok = m.Get("baz")
+	assert.Zero(v)
+	assert.False(ok)
+	assert.True(m.Has("foo"))
+	assert.False(m.Has("baz"))
+
+	prev, exist = m.Set("foo", "baz")
+	assert.True(exist)
+	assert.Equal("bar", prev)
+	assert.Equal(1, m.Len())
+
+	v, ok = m.Delete("foo")
+	assert.Equal("baz", v)
+	assert.True(ok)
+	v, ok = m.Delete("foo")
+	assert.Zero(v)
+	assert.False(ok)
+	assert.Equal(0, m.Len())
+
+	m2 := NewMapInline[int, int]()
+	for _, x := range []int{3, 5, 1, 4, 2} {
+		m2.Set(x, x)
+	}
+	m2.RangeValuePtr(func(key int, value *int) bool {
+		*value *= 10
+		return key < 3
+	})
+	var keys, values []int
+	m2.Range(func(key, value int) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	assert.Equal([]int{1, 2, 3, 4, 5}, keys)
+	assert.Equal([]int{10, 20, 30, 4, 5}, values)
+
+	keys = keys[:0]
+	m2.RangeSnapshot(func(key, _ int) bool {
+		keys = append(keys, key)
+		m2.Delete(key + 1)
+		return true
+	})
+	assert.Equal([]int{1, 2, 3, 4, 5}, keys)
+	assert.Equal(1, m2.Len())
+
+	m2.Clear()
+	assert.Equal(0, m2.Len())
+}
+
+func BenchmarkMap_set(b *testing.B) {
+	const L = 10000
+	keys := shuffle(seq(L))
+
+	b.Run("pointer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := NewMap[int, int]()
+			for _, k := range keys {
+				m.Set(k, k)
+			}
+		}
+	})
+
+	b.Run("inline", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := NewMapInline[int, int]()
+			for _, k := range keys {
+				m.Set(k, k)
+			}
+		}
+	})
+}
+
+func BenchmarkMap_get(b *testing.B) {
+	const L = 10000
+	keys := shuffle(seq(L))
+
+	m := NewMap[int, int]()
+	inline := NewMapInline[int, int]()
+	for _, k := range keys {
+		m.Set(k, k)
+		inline.Set(k, k)
+	}
+
+	b.Run("pointer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, k := range keys {
+				m.Get(k)
+			}
+		}
+	})
+
+	b.Run("inline", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, k := range keys {
+				inline.Get(k)
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+type jsonLine[K, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+// WriteJSONLines writes the key-value pairs of the map to w in ascending order
+// of the keys, as one {"key":...,"value":...} JSON object per line. Entries
+// are encoded one at a time, without building the whole document in memory.
+func (m *LLRBMap[K, V]) WriteJSONLines(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	m.Range(func(key K, value V) bool {
+		err = enc.Encode(jsonLine[K, V]{Key: key, Value: value})
+		return err == nil
+	})
+	return err
+}
+
+// WriteDelimited writes the entries of the map to w in ascending order of the
+// keys, one record per entry as rendered by format, with sep written between
+// consecutive records. With sep set to "\n" and a format joining the fields
+// with commas or tabs, it produces simple CSV or TSV output. It stops at the
+// first write error and returns it.
+func (m *LLRBMap[K, V]) WriteDelimited(w io.Writer, sep string, format func(K, V) string) error {
+	var err error
+	first := true
+	m.Range(func(key K, value V) bool {
+		if !first {
+			if _, err = io.WriteString(w, sep); err != nil {
+				return false
+			}
+		}
+		first = false
+		_, err = io.WriteString(w, format(key, value))
+		return err == nil
+	})
+	return err
+}
+
+// ReadJSONLines reads key-value pairs in the format written by WriteJSONLines
+// from r until EOF, and sets them in the map. Later lines override earlier
+// ones with the same key. On error, the pairs read so far stay in the map.
+func (m *LLRBMap[K, V]) ReadJSONLines(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var line jsonLine[K, V]
+		if err := dec.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		m.Set(line.Key, line.Value)
+	}
+}
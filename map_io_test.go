@@ -0,0 +1,104 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLRBMap_JSONLines(t *testing.T) {
+	assert := assert.New(t)
+
+	type point struct {
+		X, Y int
+	}
+	m := NewMap[string, point]()
+	m.Set("b", point{1, 2})
+	m.Set("c", point{5, 6})
+	m.Set("a", point{3, 4})
+
+	var buf bytes.Buffer
+	assert.NoError(m.WriteJSONLines(&buf))
+	assert.Equal(`{"key":"a","value":{"X":3,"Y":4}}
+{"key":"b","value":{"X":1,"Y":2}}
+{"key":"c","value":{"X":5,"Y":6}}
+`, buf.String())
+
+	m2 := NewMap[string, point]()
+	assert.NoError(m2.ReadJSONLines(&buf))
+	assert.Equal(3, m2.Len())
+	var keys []string
+	m2.Range(func(key string, value point) bool {
+		keys = append(keys, key)
+		want, _ := m.Get(key)
+		assert.Equal(want, value)
+		return true
+	})
+	assert.Equal([]string{"a", "b", "c"}, keys)
+
+	buf.Reset()
+	assert.NoError(NewMap[int, int]().WriteJSONLines(&buf))
+	assert.Empty(buf.String())
+
+	m3 := NewMap[int, int]()
+	err := m3.ReadJSONLines(strings.NewReader(`{"key":1,"value":10}
+{"key":2,"value":"x"}
+`))
+	assert.Error(err)
+	assert.Equal(1, m3.Len())
+}
+
+func TestLLRBMap_WriteDelimited(t *testing.T) {
+	assert := assert.New(t)
+
+	format := func(key string, value int) string {
+		return key + "\t" + strconv.Itoa(value)
+	}
+	var buf bytes.Buffer
+	assert.NoError(NewMap[string, int]().WriteDelimited(&buf, "\n", format))
+	assert.Empty(buf.String())
+
+	m := NewMap[string, int]()
+	m.Set("b", 2)
+	m.Set("c", 3)
+	m.Set("a", 1)
+	assert.NoError(m.WriteDelimited(&buf, "\n", format))
+	assert.Equal("a\t1\nb\t2\nc\t3", buf.String())
+
+	buf.Reset()
+	assert.NoError(m.WriteDelimited(&buf, ";", func(key string, value int) string {
+		return key + "=" + strconv.Itoa(value)
+	}))
+	assert.Equal("a=1;b=2;c=3", buf.String())
+
+	w := &limitWriter{n: 5}
+	assert.ErrorIs(m.WriteDelimited(w, "\n", format), errShortWrite)
+	assert.Equal("a\t1\nb", w.buf.String())
+}
+
+var errShortWrite = errors.New("short write")
+
+// limitWriter accepts up to n bytes and fails afterwards.
+type limitWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.buf.Write(p[:w.n])
+		n := w.n
+		w.n = 0
+		return n, errShortWrite
+	}
+	w.n -= len(p)
+	return w.buf.Write(p)
+}
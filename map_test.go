@@ -5,6 +5,9 @@
 package llrb
 
 import (
+	"math/rand"
+	"slices"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -61,3 +64,843 @@ func TestLLRBMap(t *testing.T) {
 	m2.Clear()
 	assert.Equal(0, m.Len())
 }
+
+func TestLLRBMap_AllBackward(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int, string]()
+	for range m.All() {
+		assert.Fail("unexpected pair")
+	}
+
+	for _, x := range shuffle(seq(10)) {
+		m.Set(x, strconv.Itoa(x))
+	}
+	var keys []int
+	for k, v := range m.All() {
+		assert.Equal(strconv.Itoa(k), v)
+		keys = append(keys, k)
+	}
+	assert.Equal(seq(10), keys)
+
+	keys = keys[:0]
+	for k := range m.Backward() {
+		keys = append(keys, k)
+	}
+	assert.Equal([]int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, keys)
+
+	keys = keys[:0]
+	for k := range m.All() {
+		if k > 3 {
+			break
+		}
+		keys = append(keys, k)
+	}
+	assert.Equal([]int{1, 2, 3}, keys)
+
+	keys = keys[:0]
+	for k := range m.Backward() {
+		keys = append(keys, k)
+		if k == 9 {
+			break
+		}
+	}
+	assert.Equal([]int{10, 9}, keys)
+}
+
+func TestLLRBMap_RangeDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int, string]()
+	m.RangeDelete(func(int, string) (bool, bool) {
+		assert.Fail("unexpected pair")
+		return true, false
+	})
+
+	for _, x := range shuffle(seq(1000)) {
+		m.Set(x, strconv.Itoa(x))
+	}
+	m.RangeDelete(func(key int, value string) (bool, bool) {
+		assert.Equal(strconv.Itoa(key), value)
+		return key%3 != 0, false
+	})
+	assert.Equal(667, m.Len())
+	assert.NoError(m.tr.Validate())
+	assertMaxDepth(t, m.tr)
+	m.Range(func(key int, _ string) bool {
+		assert.NotZero(key % 3)
+		return true
+	})
+
+	var visited []int
+	m.RangeDelete(func(key int, _ string) (bool, bool) {
+		visited = append(visited, key)
+		return false, key >= 4
+	})
+	assert.Equal([]int{1, 2, 4}, visited)
+	assert.Equal(664, m.Len())
+	assert.False(m.Has(4))
+	assert.True(m.Has(5))
+	assert.NoError(m.tr.Validate())
+}
+
+func TestLLRBMap_SetAll(t *testing.T) {
+	assert := assert.New(t)
+
+	pairs := func(m *LLRBMap[int, string]) map[int]string {
+		res := make(map[int]string)
+		m.Range(func(key int, value string) bool {
+			res[key] = value
+			return true
+		})
+		return res
+	}
+
+	m := NewMap[int, string]()
+	assert.NoError(m.SetAll(nil, nil))
+	assert.Equal(0, m.Len())
+
+	assert.Error(m.SetAll([]int{1, 2}, []string{"a"}))
+	assert.Error(m.SetAll(nil, []string{"a"}))
+	assert.Equal(0, m.Len())
+
+	m.Set(2, "old")
+	m.Set(10, "ten")
+	assert.NoError(m.SetAll([]int{1, 2, 3, 5}, []string{"a", "b", "c", "e"}))
+	assert.NoError(m.tr.Validate())
+	assert.Equal(map[int]string{1: "a", 2: "b", 3: "c", 5: "e", 10: "ten"}, pairs(m))
+
+	assert.NoError(m.SetAll([]int{7, 1, 7, 4}, []string{"x", "y", "z", "w"}))
+	assert.NoError(m.tr.Validate())
+	assert.Equal(map[int]string{
+		1: "y", 2: "b", 3: "c", 4: "w", 5: "e", 7: "z", 10: "ten",
+	}, pairs(m))
+
+	keys := seq(5000)
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = strconv.Itoa(k)
+	}
+	big := NewMap[int, string]()
+	assert.NoError(big.SetAll(keys, values))
+	assert.Equal(5000, big.Len())
+	assert.NoError(big.tr.Validate())
+	assertMaxDepth(t, big.tr)
+	v, _ := big.Get(4321)
+	assert.Equal("4321", v)
+}
+
+func TestLLRBMap_GroupByRange(t *testing.T) {
+	assert := assert.New(t)
+
+	type group struct {
+		index int
+		keys  []int
+	}
+	var got []group
+	collect := func(i int, entries []Pair[int, string]) bool {
+		g := group{index: i}
+		for _, p := range entries {
+			assert.Equal(strconv.Itoa(p.Key), p.Value)
+			g.keys = append(g.keys, p.Key)
+		}
+		got = append(got, g)
+		return true
+	}
+
+	m := NewMap[int, string]()
+	m.GroupByRange([]int{10, 20}, collect)
+	assert.Equal([]group{{0, nil}, {1, nil}, {2, nil}}, got)
+
+	for _, x := range []int{-5, 0, 9, 10, 15, 19, 35, 40, 41} {
+		m.Set(x, strconv.Itoa(x))
+	}
+	got = got[:0]
+	m.GroupByRange([]int{0, 10, 20, 30, 40}, collect)
+	assert.Equal([]group{
+		{0, []int{-5}},
+		{1, []int{0, 9}},
+		{2, []int{10, 15, 19}},
+		{3, nil},
+		{4, []int{35}},
+		{5, []int{40, 41}},
+	}, got)
+
+	got = got[:0]
+	m.GroupByRange(nil, collect)
+	assert.Equal([]group{{0, []int{-5, 0, 9, 10, 15, 19, 35, 40, 41}}}, got)
+
+	got = got[:0]
+	m.GroupByRange([]int{100}, collect)
+	assert.Equal([]group{{0, []int{-5, 0, 9, 10, 15, 19, 35, 40, 41}}, {1, nil}}, got)
+
+	got = got[:0]
+	m.GroupByRange([]int{0, 10, 20}, func(i int, entries []Pair[int, string]) bool {
+		collect(i, entries)
+		return i < 1
+	})
+	assert.Len(got, 2)
+}
+
+func TestLLRBMap_ReplaceExisting(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, int]()
+	old, ok := m.ReplaceExisting("a", 1)
+	assert.Zero(old)
+	assert.False(ok)
+	assert.Equal(0, m.Len())
+	assert.False(m.Has("a"))
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	old, ok = m.ReplaceExisting("a", 10)
+	assert.Equal(1, old)
+	assert.True(ok)
+	assert.Equal(2, m.Len())
+	v, _ := m.Get("a")
+	assert.Equal(10, v)
+
+	old, ok = m.ReplaceExisting("c", 3)
+	assert.Zero(old)
+	assert.False(ok)
+	assert.Equal(2, m.Len())
+	assert.False(m.Has("c"))
+}
+
+func TestLLRBMap_PurgeBefore(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int64, string]()
+	assert.Equal(0, m.PurgeBefore(100))
+
+	for ts := int64(10); ts <= 100; ts += 10 {
+		m.Set(ts, "event")
+	}
+	assert.Equal(0, m.PurgeBefore(5))
+	assert.Equal(0, m.PurgeBefore(10))
+	assert.Equal(3, m.PurgeBefore(35))
+	assert.Equal(7, m.Len())
+	assert.False(m.Has(30))
+	assert.True(m.Has(40))
+
+	assert.Equal(1, m.PurgeBefore(50))
+	var keys []int64
+	m.Range(func(key int64, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal([]int64{50, 60, 70, 80, 90, 100}, keys)
+	assert.NoError(m.tr.Validate())
+
+	assert.Equal(6, m.PurgeBefore(1000))
+	assert.Equal(0, m.Len())
+}
+
+func TestLLRBMap_KeepFirstLast(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := func(m *LLRBMap[int, string]) []int {
+		var a []int
+		m.Range(func(key int, _ string) bool {
+			a = append(a, key)
+			return true
+		})
+		return a
+	}
+	fill := func() *LLRBMap[int, string] {
+		m := NewMap[int, string]()
+		for _, x := range shuffle(seq(10)) {
+			m.Set(x, strconv.Itoa(x))
+		}
+		return m
+	}
+
+	m := NewMap[int, string]()
+	m.KeepFirst(3)
+	m.KeepLast(3)
+	assert.Equal(0, m.Len())
+
+	m = fill()
+	m.KeepFirst(20)
+	assert.Equal(10, m.Len())
+	m.KeepFirst(4)
+	assert.Equal(4, m.Len())
+	assert.Equal([]int{1, 2, 3, 4}, keys(m))
+	assert.NoError(m.tr.Validate())
+	m.KeepFirst(0)
+	assert.Equal(0, m.Len())
+
+	m = fill()
+	m.KeepLast(10)
+	assert.Equal(10, m.Len())
+	m.KeepLast(3)
+	assert.Equal(3, m.Len())
+	assert.Equal([]int{8, 9, 10}, keys(m))
+	v, _ := m.Get(9)
+	assert.Equal("9", v)
+	assert.NoError(m.tr.Validate())
+	m.KeepLast(-1)
+	assert.Equal(0, m.Len())
+}
+
+func TestLLRBMap_Rekey(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+
+	assert.True(m.Rekey("a", "c"))
+	assert.False(m.Has("a"))
+	v, ok := m.Get("c")
+	assert.Equal(1, v)
+	assert.True(ok)
+	assert.Equal(2, m.Len())
+
+	assert.False(m.Rekey("x", "y"))
+	assert.False(m.Has("y"))
+
+	assert.False(m.Rekey("b", "c"))
+	v, _ = m.Get("b")
+	assert.Equal(2, v)
+	v, _ = m.Get("c")
+	assert.Equal(1, v)
+	assert.Equal(2, m.Len())
+
+	assert.False(m.Rekey("b", "b"))
+}
+
+func TestLLRBMap_RangeValuesWhere(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, int]()
+	for i, key := range []string{"f", "b", "d", "a", "e", "c"} {
+		m.Set(key, i)
+	}
+	isEven := func(v int) bool { return v%2 == 0 }
+
+	var keys []string
+	var values []int
+	m.RangeValuesWhere(isEven, func(key string, value int) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	assert.Equal([]string{"d", "e", "f"}, keys)
+	assert.Equal([]int{2, 4, 0}, values)
+
+	keys = keys[:0]
+	m.RangeValuesWhere(isEven, func(key string, _ int) bool {
+		keys = append(keys, key)
+		return false
+	})
+	assert.Equal([]string{"d"}, keys)
+
+	m.RangeValuesWhere(func(int) bool { return false }, func(string, int) bool {
+		assert.Fail("unexpected entry")
+		return true
+	})
+}
+
+func TestLLRBMap_RangeSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int, string]()
+	m.Set(1, "a")
+	m.Set(3, "c")
+	m.Set(5, "e")
+
+	var keys []int
+	m.RangeSnapshot(func(key int, value string) bool {
+		keys = append(keys, key)
+		m.Set(key+1, value+value)
+		m.Set(key*10, value)
+		m.Delete(5)
+		return true
+	})
+	assert.Equal([]int{1, 3, 5}, keys)
+	assert.Equal(8, m.Len())
+	v, _ := m.Get(6)
+	assert.Equal("ee", v)
+
+	keys = keys[:0]
+	m.RangeSnapshot(func(key int, _ string) bool {
+		keys = append(keys, key)
+		m.Clear()
+		return key < 2
+	})
+	assert.Equal([]int{1, 2}, keys)
+	assert.Equal(0, m.Len())
+}
+
+func TestLLRBMap_RangeValuePtr(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, []int]()
+	m.Set("a", []int{1})
+	m.Set("b", []int{2})
+	m.Set("c", []int{3})
+
+	var keys []string
+	m.RangeValuePtr(func(key string, value *[]int) bool {
+		keys = append(keys, key)
+		*value = append(*value, len(key)*10)
+		return key < "b"
+	})
+	assert.Equal([]string{"a", "b"}, keys)
+
+	v, _ := m.Get("a")
+	assert.Equal([]int{1, 10}, v)
+	v, _ = m.Get("b")
+	assert.Equal([]int{2, 10}, v)
+	v, _ = m.Get("c")
+	assert.Equal([]int{3}, v)
+
+	counters := NewMap[int, int]()
+	for i := 0; i < 10; i++ {
+		counters.Set(i, i)
+	}
+	counters.RangeValuePtr(func(_ int, value *int) bool {
+		*value *= 2
+		return true
+	})
+	counters.Range(func(key, value int) bool {
+		assert.Equal(2*key, value)
+		return true
+	})
+}
+
+func TestRangeByValue(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, int]()
+	RangeByValue(m, func(string, int) bool {
+		assert.Fail("unexpected entry")
+		return true
+	})
+
+	m.Set("e", 3)
+	m.Set("a", 2)
+	m.Set("d", 1)
+	m.Set("b", 3)
+	m.Set("c", 2)
+
+	var keys []string
+	var values []int
+	RangeByValue(m, func(key string, value int) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	assert.Equal([]string{"d", "a", "c", "b", "e"}, keys)
+	assert.Equal([]int{1, 2, 2, 3, 3}, values)
+
+	keys = keys[:0]
+	RangeByValue(m, func(key string, value int) bool {
+		keys = append(keys, key)
+		m.Delete(key)
+		return value < 2
+	})
+	assert.Equal([]string{"d", "a"}, keys)
+	assert.Equal(3, m.Len())
+}
+
+func TestLLRBMap_ChangedKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	eq := func(a, b []int) bool { return slices.Equal(a, b) }
+	m := NewMap[string, []int]()
+	other := NewMap[string, []int]()
+	assert.Empty(m.ChangedKeys(other, eq))
+
+	m.Set("a", []int{1})
+	m.Set("b", []int{2})
+	m.Set("c", []int{3})
+	m.Set("e", []int{5})
+	m.Set("g", []int{7})
+	other.Set("b", []int{2})
+	other.Set("c", []int{3, 3})
+	other.Set("d", []int{4})
+	other.Set("e", nil)
+	other.Set("g", []int{7})
+
+	assert.Equal([]string{"c", "e"}, m.ChangedKeys(other, eq))
+	assert.Equal([]string{"c", "e"}, other.ChangedKeys(m, eq))
+	assert.Empty(m.ChangedKeys(m, eq))
+	assert.Empty(m.ChangedKeys(NewMap[string, []int](), eq))
+}
+
+func TestTopByValue(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[string, int]()
+	assert.Empty(TopByValue(m, 3))
+
+	m.Set("alice", 50)
+	m.Set("bob", 80)
+	m.Set("carol", 80)
+	m.Set("dave", 20)
+	m.Set("erin", 95)
+	m.Set("frank", 50)
+	assert.Nil(TopByValue(m, 0))
+	assert.Nil(TopByValue(m, -1))
+	assert.Equal([]Pair[string, int]{{"erin", 95}}, TopByValue(m, 1))
+	assert.Equal([]Pair[string, int]{
+		{"erin", 95},
+		{"bob", 80},
+		{"carol", 80},
+		{"alice", 50},
+	}, TopByValue(m, 4))
+	assert.Len(TopByValue(m, 100), 6)
+	assert.Equal(Pair[string, int]{"dave", 20}, TopByValue(m, 100)[5])
+
+	scores := NewMap[int, int]()
+	for _, k := range rnd(1000, 5000) {
+		scores.Set(k, rand.Intn(100))
+	}
+	var want []Pair[int, int]
+	scores.Range(func(key, value int) bool {
+		want = append(want, Pair[int, int]{key, value})
+		return true
+	})
+	slices.SortStableFunc(want, func(a, b Pair[int, int]) int {
+		return b.Value - a.Value
+	})
+	assert.Equal(want[:50], TopByValue(scores, 50))
+}
+
+func TestPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	from := NewMap[string, int]()
+	to := NewMap[string, int]()
+
+	added, removed, changed := Patch(from, to)
+	assert.Empty(added)
+	assert.Empty(removed)
+	assert.Empty(changed)
+
+	from.Set("a", 1)
+	from.Set("b", 2)
+	from.Set("c", 3)
+	from.Set("e", 5)
+	to.Set("b", 2)
+	to.Set("c", 30)
+	to.Set("d", 4)
+	to.Set("e", 50)
+	to.Set("f", 6)
+
+	added, removed, changed = Patch(from, to)
+	assert.Equal([]string{"d", "f"}, added)
+	assert.Equal([]string{"a"}, removed)
+	assert.Equal([]string{"c", "e"}, changed)
+
+	added, removed, changed = Patch(to, from)
+	assert.Equal([]string{"a"}, added)
+	assert.Equal([]string{"d", "f"}, removed)
+	assert.Equal([]string{"c", "e"}, changed)
+
+	added, removed, changed = Patch(from, from)
+	assert.Empty(added)
+	assert.Empty(removed)
+	assert.Empty(changed)
+
+	added, removed, _ = Patch(NewMap[string, int](), to)
+	assert.Equal([]string{"b", "c", "d", "e", "f"}, added)
+	assert.Empty(removed)
+}
+
+func TestScanDescending(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int, float64]()
+	for i, v := range []float64{1, 2, 3, 4} {
+		m.Set(i*10, v)
+	}
+
+	var keys []int
+	var sums []float64
+	ScanDescending(m, 0.0, func(acc float64, _ int, value float64) float64 {
+		return acc + value
+	}, func(key int, _, acc float64) bool {
+		keys = append(keys, key)
+		sums = append(sums, acc)
+		return true
+	})
+	assert.Equal([]int{30, 20, 10, 0}, keys)
+	assert.Equal([]float64{4, 7, 9, 10}, sums)
+
+	keys = keys[:0]
+	ScanDescending(m, 0, func(acc, _ int, _ float64) int {
+		return acc + 1
+	}, func(key int, _ float64, acc int) bool {
+		keys = append(keys, key)
+		return acc < 2
+	})
+	assert.Equal([]int{30, 20}, keys)
+}
+
+func TestWindowSum(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int64, float64]()
+	assert.Zero(WindowSum(m, 0, 100))
+
+	keys := rnd(1000, 5000)
+	for _, k := range keys {
+		m.Set(int64(k), float64(k%7)+0.5)
+	}
+
+	for i := 0; i < 100; i++ {
+		from := int64(rand.Intn(6000) - 500)
+		to := from + int64(rand.Intn(1000))
+		want := 0.0
+		m.Range(func(key int64, value float64) bool {
+			if key >= from && key < to {
+				want += value
+			}
+			return true
+		})
+		assert.InDelta(want, WindowSum(m, from, to), 1e-9)
+	}
+	assert.Zero(WindowSum(m, 10, 10))
+	assert.Zero(WindowSum(m, 10, 5))
+
+	ints := NewMap[string, int]()
+	ints.Set("a", 1)
+	ints.Set("b", 2)
+	ints.Set("c", 4)
+	assert.Equal(3, WindowSum(ints, "a", "c"))
+}
+
+func TestMovingAverage(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMap[int64, float64]()
+	avg, n := MovingAverage(m, 0, 100)
+	assert.Zero(avg)
+	assert.Zero(n)
+
+	for _, k := range rnd(1000, 5000) {
+		m.Set(int64(k), rand.Float64()*100)
+	}
+	for i := 0; i < 100; i++ {
+		from := int64(rand.Intn(6000) - 500)
+		to := from + int64(rand.Intn(1000))
+		sum, count := 0.0, 0
+		m.Range(func(key int64, value float64) bool {
+			if key >= from && key < to {
+				sum += value
+				count++
+			}
+			return true
+		})
+		avg, n := MovingAverage(m, from, to)
+		assert.Equal(count, n)
+		if count > 0 {
+			assert.InDelta(sum/float64(count), avg, 1e-9)
+		} else {
+			assert.Zero(avg)
+		}
+	}
+	avg, n = MovingAverage(m, 10, 5)
+	assert.Zero(avg)
+	assert.Zero(n)
+
+	ints := NewMap[int, int]()
+	ints.Set(1, 1)
+	ints.Set(2, 2)
+	ints.Set(3, 6)
+	avg, n = MovingAverage(ints, 1, 3)
+	assert.Equal(1.5, avg)
+	assert.Equal(2, n)
+}
+
+func TestZip(t *testing.T) {
+	assert := assert.New(t)
+
+	names := NewMap[int, string]()
+	ages := NewMap[int, int]()
+	assert.Equal(0, Zip(names, ages).Len())
+
+	names.Set(1, "alice")
+	names.Set(2, "bob")
+	names.Set(4, "dave")
+	ages.Set(5, 50)
+	ages.Set(6, 60)
+	assert.Equal(0, Zip(names, ages).Len())
+
+	ages.Set(2, 20)
+	ages.Set(4, 40)
+	ages.Set(3, 30)
+	z := Zip(names, ages)
+	assert.Equal(2, z.Len())
+	assert.NoError(z.tr.Validate())
+
+	type pair = struct {
+		A string
+		B int
+	}
+	var keys []int
+	z.Range(func(key int, value pair) bool {
+		keys = append(keys, key)
+		a, _ := names.Get(key)
+		b, _ := ages.Get(key)
+		assert.Equal(a, value.A)
+		assert.Equal(b, value.B)
+		return true
+	})
+	assert.Equal([]int{2, 4}, keys)
+
+	v, ok := Zip(names, names).Get(1)
+	assert.True(ok)
+	assert.Equal("alice", v.A)
+	assert.Equal("alice", v.B)
+}
+
+func TestLeftJoin(t *testing.T) {
+	assert := assert.New(t)
+
+	type row struct {
+		key     int
+		name    string
+		age     int
+		matched bool
+	}
+	names := NewMap[int, string]()
+	ages := NewMap[int, int]()
+	var rows []row
+	collect := func(key int, name string, age int, matched bool) bool {
+		rows = append(rows, row{key, name, age, matched})
+		return true
+	}
+	LeftJoin(names, ages, collect)
+	assert.Empty(rows)
+
+	names.Set(1, "alice")
+	names.Set(2, "bob")
+	names.Set(4, "dave")
+	names.Set(7, "grace")
+	ages.Set(0, 5)
+	ages.Set(2, 20)
+	ages.Set(3, 30)
+	ages.Set(4, 40)
+	ages.Set(9, 90)
+
+	LeftJoin(names, ages, collect)
+	assert.Equal([]row{
+		{1, "alice", 0, false},
+		{2, "bob", 20, true},
+		{4, "dave", 40, true},
+		{7, "grace", 0, false},
+	}, rows)
+
+	rows = rows[:0]
+	LeftJoin(names, NewMap[int, int](), collect)
+	assert.Len(rows, 4)
+	for _, r := range rows {
+		assert.False(r.matched)
+	}
+
+	rows = rows[:0]
+	LeftJoin(names, ages, func(key int, name string, age int, matched bool) bool {
+		rows = append(rows, row{key, name, age, matched})
+		return key < 2
+	})
+	assert.Len(rows, 2)
+}
+
+func TestCountBy(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, CountBy[string](nil).Len())
+
+	m := CountBy([]string{"b", "a", "c", "b", "a", "b", "d"})
+	assert.Equal(4, m.Len())
+
+	var keys []string
+	var counts []int
+	m.Range(func(key string, count int) bool {
+		keys = append(keys, key)
+		counts = append(counts, count)
+		return true
+	})
+	assert.Equal([]string{"a", "b", "c", "d"}, keys)
+	assert.Equal([]int{2, 3, 1, 1}, counts)
+
+	a := rnd(1000, 50)
+	m2 := CountBy(a)
+	total := 0
+	m2.Range(func(key, count int) bool {
+		total += count
+		return true
+	})
+	assert.Equal(len(a), total)
+	assert.Equal(uniq(a), m2.Len())
+}
+
+func TestPrefixCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	collect := func(m *LLRBMap[string, int]) map[string]int {
+		res := make(map[string]int)
+		m.Range(func(key string, count int) bool {
+			res[key] = count
+			return true
+		})
+		return res
+	}
+
+	m := NewMap[string, bool]()
+	assert.Equal(0, PrefixCounts(m, 2).Len())
+
+	keys := []string{"apple", "apricot", "avocado", "banana", "blueberry", "b", "cherry"}
+	for _, k := range keys {
+		m.Set(k, true)
+	}
+	counts := PrefixCounts(m, 2)
+	assert.NoError(counts.tr.Validate())
+	assert.Equal(map[string]int{
+		"ap": 2, "av": 1, "b": 1, "ba": 1, "bl": 1, "ch": 1,
+	}, collect(counts))
+	assert.Equal(map[string]int{"a": 3, "b": 3, "c": 1}, collect(PrefixCounts(m, 1)))
+	assert.Equal(map[string]int{"": 7}, collect(PrefixCounts(m, 0)))
+	assert.Equal(7, PrefixCounts(m, 100).Len())
+}
+
+func TestFlattenPairs(t *testing.T) {
+	assert := assert.New(t)
+
+	type pair struct {
+		k string
+		v int
+	}
+	m := NewMap[string, *LLRBSet[int]]()
+	var got []pair
+	collect := func(k string, v int) bool {
+		got = append(got, pair{k, v})
+		return true
+	}
+	FlattenPairs(m, collect)
+	assert.Empty(got)
+
+	for k, vs := range map[string][]int{"c": {2, 1}, "a": {3, 1, 2}, "b": nil, "d": {5}} {
+		set := NewSet[int]()
+		for _, v := range vs {
+			set.Insert(v)
+		}
+		m.Set(k, set)
+	}
+	m.Set("e", nil)
+
+	FlattenPairs(m, collect)
+	assert.Equal([]pair{{"a", 1}, {"a", 2}, {"a", 3}, {"c", 1}, {"c", 2}, {"d", 5}}, got)
+
+	got = got[:0]
+	FlattenPairs(m, func(k string, v int) bool {
+		got = append(got, pair{k, v})
+		return len(got) < 4
+	})
+	assert.Equal([]pair{{"a", 1}, {"a", 2}, {"a", 3}, {"c", 1}}, got)
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"cmp"
+	"slices"
+)
+
+// LLRBMultiSet represents an ordered multiset, which may hold several copies
+// of the same value, implemented on top of an LLRBMap from values to counts.
+type LLRBMultiSet[T cmp.Ordered] struct {
+	m   *LLRBMap[T, int]
+	len int
+}
+
+// NewMultiSet creates a new LLRBMultiSet.
+func NewMultiSet[T cmp.Ordered]() *LLRBMultiSet[T] {
+	return &LLRBMultiSet[T]{
+		m: NewMap[T, int](),
+	}
+}
+
+// Add adds one copy of the value to the multiset.
+func (s *LLRBMultiSet[T]) Add(item T) {
+	if ent, ok := s.m.tr.Get(&entry[T, int]{key: item}); ok {
+		ent.value++
+	} else {
+		s.m.Set(item, 1)
+	}
+	s.len++
+}
+
+// Remove removes one copy of the value from the multiset. The value is
+// removed entirely once its count drops to zero.
+// It returns true if the value existed in the multiset, false otherwise.
+func (s *LLRBMultiSet[T]) Remove(item T) bool {
+	ent, ok := s.m.tr.Get(&entry[T, int]{key: item})
+	if !ok {
+		return false
+	}
+	if ent.value == 1 {
+		s.m.Delete(item)
+	} else {
+		ent.value--
+	}
+	s.len--
+	return true
+}
+
+// Count returns the number of copies of the value in the multiset.
+func (s *LLRBMultiSet[T]) Count(item T) int {
+	n, _ := s.m.Get(item)
+	return n
+}
+
+// Range iterates over the distinct values in the multiset in ascending
+// order, along with their counts.
+// Iteration stops if the callback function returns false.
+func (s *LLRBMultiSet[T]) Range(iter func(item T, count int) bool) {
+	s.m.Range(iter)
+}
+
+// TopCounts returns up to k distinct values with the highest counts, in
+// descending order of count. Values with equal counts are ordered by
+// ascending value.
+func (s *LLRBMultiSet[T]) TopCounts(k int) []ItemCount[T] {
+	if k <= 0 {
+		return nil
+	}
+	counts := make([]ItemCount[T], 0, s.m.Len())
+	s.m.Range(func(item T, count int) bool {
+		counts = append(counts, ItemCount[T]{Item: item, Count: count})
+		return true
+	})
+	slices.SortStableFunc(counts, func(a, b ItemCount[T]) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return counts[:min(k, len(counts))]
+}
+
+// Len returns the number of values in the multiset, counting every copy.
+func (s *LLRBMultiSet[T]) Len() int {
+	return s.len
+}
+
+// Clear removes all values from the multiset, resulting in an empty multiset.
+func (s *LLRBMultiSet[T]) Clear() {
+	s.m.Clear()
+	s.len = 0
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLRBMultiSet(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewMultiSet[string]()
+	for _, x := range []string{"b", "a", "c", "b", "a", "b"} {
+		s.Add(x)
+	}
+	assert.Equal(6, s.Len())
+	assert.Equal(2, s.Count("a"))
+	assert.Equal(3, s.Count("b"))
+	assert.Equal(1, s.Count("c"))
+	assert.Equal(0, s.Count("d"))
+
+	type pair struct {
+		item  string
+		count int
+	}
+	var collect []pair
+	s.Range(func(item string, count int) bool {
+		collect = append(collect, pair{item, count})
+		return true
+	})
+	assert.Equal([]pair{{"a", 2}, {"b", 3}, {"c", 1}}, collect)
+
+	assert.True(s.Remove("c"))
+	assert.False(s.Remove("c"))
+	assert.False(s.Remove("d"))
+	assert.True(s.Remove("a"))
+	assert.Equal(1, s.Count("a"))
+	assert.Equal(4, s.Len())
+	assert.Equal(2, s.m.Len())
+
+	collect = collect[:0]
+	s.Range(func(item string, count int) bool {
+		collect = append(collect, pair{item, count})
+		return false
+	})
+	assert.Equal([]pair{{"a", 1}}, collect)
+
+	s.Clear()
+	assert.Equal(0, s.Len())
+	assert.Equal(0, s.Count("b"))
+}
+
+func TestLLRBMultiSet_TopCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewMultiSet[string]()
+	assert.Empty(s.TopCounts(3))
+
+	for item, n := range map[string]int{"a": 1, "b": 4, "c": 2, "d": 4, "e": 2, "f": 3} {
+		for i := 0; i < n; i++ {
+			s.Add(item)
+		}
+	}
+
+	assert.Nil(s.TopCounts(0))
+	assert.Equal([]ItemCount[string]{{"b", 4}}, s.TopCounts(1))
+	assert.Equal([]ItemCount[string]{{"b", 4}, {"d", 4}, {"f", 3}}, s.TopCounts(3))
+	assert.Equal([]ItemCount[string]{
+		{"b", 4}, {"d", 4}, {"f", 3}, {"c", 2}, {"e", 2}, {"a", 1},
+	}, s.TopCounts(10))
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+// Option configures optional behavior of an LLRB-Tree at construction time.
+type Option func(*options)
+
+type options struct {
+	insertionOrder bool
+	trackHeight    bool
+	countRotations bool
+}
+
+// WithInsertionOrder makes the tree record the order in which items are
+// inserted, so that they can be visited with AscendByInsertion.
+//
+// Every inserted item is stamped with a 64-bit sequence number, which is
+// kept in a map on the side, so that trees without the option do not pay
+// for it. Each item costs one map entry.
+func WithInsertionOrder() Option {
+	return func(o *options) {
+		o.insertionOrder = true
+	}
+}
+
+// WithHeightTracking makes the tree maintain the height of every subtree as
+// it is rebalanced, so that Height runs in O(1) instead of walking the whole
+// tree. It adds a small constant overhead to every update. The heights are
+// packed into the same word as the subtree sizes, so the option takes no
+// extra memory.
+func WithHeightTracking() Option {
+	return func(o *options) {
+		o.trackHeight = true
+	}
+}
+
+// WithRotationCounter makes the tree count the rotations it performs while
+// rebalancing, which can be read with RotationCount to gauge the
+// rebalancing cost of an access pattern.
+func WithRotationCounter() Option {
+	return func(o *options) {
+		o.countRotations = true
+	}
+}
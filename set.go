@@ -6,6 +6,10 @@ package llrb
 
 import (
 	"cmp"
+	"container/heap"
+	"iter"
+	"math"
+	"slices"
 )
 
 // LLRBSet represents a set data structure implemented using a Left-Leaning Red-Black Tree.
@@ -47,12 +51,293 @@ func (s *LLRBSet[T]) Has(item T) bool {
 	return s.tr.Has(item)
 }
 
+// WouldChange returns true if Insert of the given value would add it to the
+// set, i.e. it doesn't exist in the set yet. The set is not modified.
+func (s *LLRBSet[T]) WouldChange(item T) bool {
+	return s.tr.WouldChange(item)
+}
+
+// Min returns the smallest value in the set, or (zeroValue, false) if the
+// set is empty.
+func (s *LLRBSet[T]) Min() (T, bool) {
+	return s.tr.Min()
+}
+
+// Max returns the largest value in the set, or (zeroValue, false) if the set
+// is empty.
+func (s *LLRBSet[T]) Max() (T, bool) {
+	return s.tr.Max()
+}
+
 // Len returns the number of values in the set.
 func (s *LLRBSet[T]) Len() int {
 	return s.tr.Len()
 }
 
+// FilterPresent returns the items of the given slice that exist in the set,
+// preserving their order in the input.
+func (s *LLRBSet[T]) FilterPresent(items []T) []T {
+	return s.filter(items, true)
+}
+
+// FilterAbsent returns the items of the given slice that do not exist in the
+// set, preserving their order in the input.
+func (s *LLRBSet[T]) FilterAbsent(items []T) []T {
+	return s.filter(items, false)
+}
+
+func (s *LLRBSet[T]) filter(items []T, present bool) []T {
+	res := make([]T, 0, len(items))
+	for _, x := range items {
+		if s.tr.Has(x) == present {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// IntersectSliceCount returns the number of distinct items of the given slice
+// that exist in the set. Duplicates in the input are counted only once. A
+// sorted copy of the items is merged with the set in a single linear pass.
+func (s *LLRBSet[T]) IntersectSliceCount(items []T) int {
+	sorted := slices.Clone(items)
+	slices.SortFunc(sorted, cmp.Compare[T])
+	c := newCursor(s.tr.root)
+	n := 0
+	for i, x := range sorted {
+		if i > 0 && cmp.Compare(sorted[i-1], x) == 0 {
+			continue
+		}
+		y, ok := c.peek()
+		for ok && cmp.Compare(y, x) < 0 {
+			c.next()
+			y, ok = c.peek()
+		}
+		if !ok {
+			return n
+		}
+		if cmp.Compare(y, x) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// DiffCounts returns the number of values only in s, only in other, and in
+// both sets, computed with a single linear merge of the two sets. Only the
+// common values are counted; the other two counts follow from the lengths.
+func (s *LLRBSet[T]) DiffCounts(other *LLRBSet[T]) (onlyThis, onlyOther, both int) {
+	a, b := newCursor(s.tr.root), newCursor(other.tr.root)
+	for {
+		x, okx := a.peek()
+		y, oky := b.peek()
+		if !okx || !oky {
+			// Whatever is left in either set has no match in the other.
+			return s.Len() - both, other.Len() - both, both
+		}
+		switch cmp.Compare(x, y) {
+		case -1:
+			a.next()
+		case 1:
+			b.next()
+		default:
+			both++
+			a.next()
+			b.next()
+		}
+	}
+}
+
+// Chunk splits the values of the set, in ascending order, into contiguous
+// chunks of size values each, except for the last one which may be smaller.
+// It returns nil if the set is empty or size is not positive.
+func (s *LLRBSet[T]) Chunk(size int) [][]T {
+	if size <= 0 || s.Len() == 0 {
+		return nil
+	}
+	items := s.tr.items()
+	chunks := make([][]T, 0, (len(items)+size-1)/size)
+	for lo := 0; lo < len(items); lo += size {
+		hi := min(lo+size, len(items))
+		chunks = append(chunks, items[lo:hi:hi])
+	}
+	return chunks
+}
+
 // Clear removes all values from the set, resulting in an empty set.
 func (s *LLRBSet[T]) Clear() {
 	s.tr.Clear()
 }
+
+// UnionSeq returns a sequence that yields the union of all the given sets in
+// ascending order. The sets are merged lazily, so no result set is built and
+// every value is yielded only once.
+func UnionSeq[T cmp.Ordered](sets ...*LLRBSet[T]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		h := make(cursorHeap[T], 0, len(sets))
+		for _, s := range sets {
+			c := newCursor(s.tr.root)
+			if _, ok := c.peek(); ok {
+				h = append(h, c)
+			}
+		}
+		heap.Init(&h)
+
+		var (
+			last    T
+			started bool
+		)
+		for len(h) > 0 {
+			c := h[0]
+			x, _ := c.next()
+			if _, ok := c.peek(); ok {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+			if started && cmp.Compare(x, last) == 0 {
+				continue
+			}
+			last, started = x, true
+			if !yield(x) {
+				return
+			}
+		}
+	}
+}
+
+// cursorHeap is a min-heap of cursors ordered by their current item.
+type cursorHeap[T cmp.Ordered] []*cursor[T]
+
+func (h cursorHeap[T]) Len() int { return len(h) }
+
+func (h cursorHeap[T]) Less(i, j int) bool {
+	x, _ := h[i].peek()
+	y, _ := h[j].peek()
+	return cmp.Less(x, y)
+}
+
+func (h cursorHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *cursorHeap[T]) Push(x any) { *h = append(*h, x.(*cursor[T])) }
+
+func (h *cursorHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// LongestConsecutive returns the first value and the length of the longest
+// run of consecutive integers in the set. If there are several runs of the
+// same length, the smallest one is returned. It returns (0, 0) for an empty
+// set.
+func LongestConsecutive(s *LLRBSet[int]) (start, length int) {
+	var runStart, runLen, prev int
+	s.Range(func(x int) bool {
+		if runLen > 0 && x == prev+1 {
+			runLen++
+		} else {
+			runStart, runLen = x, 1
+		}
+		if runLen > length {
+			start, length = runStart, runLen
+		}
+		prev = x
+		return true
+	})
+	return start, length
+}
+
+// ContiguousRuns returns the minimal list of closed intervals [start, end],
+// in ascending order, that cover exactly the values of the set, merging
+// consecutive integers into a single run. It returns nil for an empty set.
+func ContiguousRuns(s *LLRBSet[int]) [][2]int {
+	var runs [][2]int
+	s.Range(func(x int) bool {
+		if n := len(runs); n > 0 && runs[n-1][1] == x-1 {
+			runs[n-1][1] = x
+		} else {
+			runs = append(runs, [2]int{x, x})
+		}
+		return true
+	})
+	return runs
+}
+
+// WithinDistance returns, in ascending order, the values of the set within
+// distance d of target, that is the values in [target-d, target+d]. The
+// bounds saturate at the limits of int. It returns nil if d is negative.
+func WithinDistance(s *LLRBSet[int], target, d int) []int {
+	if d < 0 {
+		return nil
+	}
+	lo, hi := math.MinInt, math.MaxInt
+	if target >= math.MinInt+d {
+		lo = target - d
+	}
+	if target <= math.MaxInt-d {
+		hi = target + d
+	}
+	var res []int
+	s.tr.AscendGreaterOrEqual(lo, func(x int) bool {
+		if x > hi {
+			return false
+		}
+		res = append(res, x)
+		return true
+	})
+	return res
+}
+
+// Complement returns a new set holding every integer in [lo, hi] that is not
+// in s. It walks the values of s within the range once and fills the gaps
+// between them, and builds the result in linear time.
+func Complement(s *LLRBSet[int], lo, hi int) *LLRBSet[int] {
+	var gaps []int
+	fill := func(from, to int) {
+		for x := from; x <= to; x++ {
+			gaps = append(gaps, x)
+			if x == to {
+				break
+			}
+		}
+	}
+
+	next, done := lo, false
+	s.tr.AscendGreaterOrEqual(lo, func(x int) bool {
+		if x > hi {
+			return false
+		}
+		if x > next {
+			fill(next, x-1)
+		}
+		if x == math.MaxInt {
+			done = true
+			return false
+		}
+		next = x + 1
+		return true
+	})
+	if !done && next <= hi {
+		fill(next, hi)
+	}
+
+	res := NewSet[int]()
+	res.tr.MergeSortedSlice(gaps)
+	return res
+}
+
+// MapSeq returns a sequence that yields fn(x) for every value x of the set in
+// ascending order. The values are transformed lazily, as the sequence is
+// consumed.
+func MapSeq[T cmp.Ordered, U any](s *LLRBSet[T], fn func(T) U) iter.Seq[U] {
+	return func(yield func(U) bool) {
+		for x := range s.tr.Items() {
+			if !yield(fn(x)) {
+				return
+			}
+		}
+	}
+}
@@ -5,6 +5,10 @@
 package llrb
 
 import (
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,3 +62,354 @@ func TestLLRBSet(t *testing.T) {
 		}
 	}
 }
+
+func TestLLRBSet_filter(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	for _, x := range []int{1, 3, 5, 7, 9} {
+		s.Insert(x)
+	}
+
+	items := []int{9, 2, 3, 4, 1, 3}
+	assert.Equal([]int{9, 3, 1, 3}, s.FilterPresent(items))
+	assert.Equal([]int{2, 4}, s.FilterAbsent(items))
+
+	items = []int{2, 4, 6}
+	assert.Equal([]int{}, s.FilterPresent(items))
+	assert.Equal([]int{2, 4, 6}, s.FilterAbsent(items))
+
+	assert.Equal([]int{}, s.FilterPresent(nil))
+	assert.Equal([]int{}, s.FilterAbsent(nil))
+}
+
+func TestLLRBSet_WouldChange(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[string]()
+	assert.True(s.WouldChange("a"))
+	s.Insert("a")
+	assert.False(s.WouldChange("a"))
+	assert.True(s.WouldChange("b"))
+	assert.Equal(1, s.Len())
+}
+
+func TestLLRBSet_IntersectSliceCount(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	for _, x := range []int{1, 2, 3, 4, 5} {
+		s.Insert(x)
+	}
+
+	assert.Equal(0, s.IntersectSliceCount(nil))
+	assert.Equal(0, s.IntersectSliceCount([]int{6, 7, 6}))
+	assert.Equal(2, s.IntersectSliceCount([]int{0, 2, 4, 6}))
+	assert.Equal(2, s.IntersectSliceCount([]int{2, 2, 4, 2, 4, 8}))
+	assert.Equal(5, s.IntersectSliceCount([]int{5, 4, 3, 2, 1, 1}))
+
+	nan := math.NaN()
+	floats := NewSet[float64]()
+	floats.Insert(nan)
+	floats.Insert(1)
+	assert.Equal(2, floats.IntersectSliceCount([]float64{nan, nan, nan, 1, 1}))
+	assert.Equal(1, floats.IntersectSliceCount([]float64{2, nan, nan}))
+}
+
+func TestLLRBSet_DiffCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	s1, s2 := NewSet[int](), NewSet[int]()
+	onlyThis, onlyOther, both := s1.DiffCounts(s2)
+	assert.Zero(onlyThis)
+	assert.Zero(onlyOther)
+	assert.Zero(both)
+
+	for i := 0; i < 20; i++ {
+		s1.Clear()
+		s2.Clear()
+		a, b := rnd(rand.Intn(200), 300), rnd(rand.Intn(200), 300)
+		for _, x := range a {
+			s1.Insert(x)
+		}
+		for _, x := range b {
+			s2.Insert(x)
+		}
+
+		var wantThis, wantOther, wantBoth int
+		s1.Range(func(x int) bool {
+			if s2.Has(x) {
+				wantBoth++
+			} else {
+				wantThis++
+			}
+			return true
+		})
+		s2.Range(func(x int) bool {
+			if !s1.Has(x) {
+				wantOther++
+			}
+			return true
+		})
+
+		onlyThis, onlyOther, both = s1.DiffCounts(s2)
+		assert.Equal(wantThis, onlyThis)
+		assert.Equal(wantOther, onlyOther)
+		assert.Equal(wantBoth, both)
+	}
+}
+
+func TestLLRBSet_MinMax(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[string]()
+	_, ok := s.Min()
+	assert.False(ok)
+	_, ok = s.Max()
+	assert.False(ok)
+
+	for _, x := range []string{"m", "c", "x", "a", "q"} {
+		s.Insert(x)
+	}
+	lo, ok := s.Min()
+	assert.True(ok)
+	assert.Equal("a", lo)
+	hi, ok := s.Max()
+	assert.True(ok)
+	assert.Equal("x", hi)
+	assert.Equal(5, s.Len())
+}
+
+func TestLLRBSet_Chunk(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	assert.Nil(s.Chunk(3))
+
+	for _, x := range shuffle(seq(10)) {
+		s.Insert(x)
+	}
+	assert.Nil(s.Chunk(0))
+	assert.Nil(s.Chunk(-1))
+	assert.Equal([][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10}}, s.Chunk(3))
+	assert.Equal([][]int{{1, 2, 3, 4, 5}, {6, 7, 8, 9, 10}}, s.Chunk(5))
+	assert.Equal([][]int{seq(10)}, s.Chunk(100))
+
+	s.Clear()
+	a := rnd(1000, 5000)
+	for _, x := range a {
+		s.Insert(x)
+	}
+	for _, size := range []int{1, 7, 64, 999, 1000} {
+		chunks := s.Chunk(size)
+		var all []int
+		for i, chunk := range chunks {
+			if i < len(chunks)-1 {
+				assert.Len(chunk, size)
+			} else {
+				assert.LessOrEqual(len(chunk), size)
+				assert.NotEmpty(chunk)
+			}
+			if i > 0 {
+				prev := chunks[i-1]
+				assert.Less(prev[len(prev)-1], chunk[0])
+			}
+			all = append(all, chunk...)
+		}
+		assert.Len(all, s.Len())
+		assert.True(slices.IsSorted(all))
+	}
+
+	chunks := s.Chunk(2)
+	chunks[0] = append(chunks[0], -1)
+	assert.NotEqual(-1, chunks[1][0])
+}
+
+func TestLongestConsecutive(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	start, length := LongestConsecutive(s)
+	assert.Equal(0, start)
+	assert.Equal(0, length)
+
+	s.Insert(7)
+	start, length = LongestConsecutive(s)
+	assert.Equal(7, start)
+	assert.Equal(1, length)
+
+	for _, x := range []int{-3, -2, -1, 0, 5, 6, 8, 9, 10, 20, 21} {
+		s.Insert(x)
+	}
+	start, length = LongestConsecutive(s)
+	assert.Equal(5, start)
+	assert.Equal(6, length)
+
+	s.Delete(7)
+	start, length = LongestConsecutive(s)
+	assert.Equal(-3, start)
+	assert.Equal(4, length)
+}
+
+func TestContiguousRuns(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	assert.Nil(ContiguousRuns(s))
+
+	s.Insert(4)
+	assert.Equal([][2]int{{4, 4}}, ContiguousRuns(s))
+
+	for _, x := range []int{-3, -2, -1, 0, 1, 5, 9, 11, 12, 13} {
+		s.Insert(x)
+	}
+	assert.Equal([][2]int{{-3, 1}, {4, 5}, {9, 9}, {11, 13}}, ContiguousRuns(s))
+
+	s.Insert(math.MinInt)
+	s.Insert(math.MaxInt)
+	runs := ContiguousRuns(s)
+	assert.Equal([2]int{math.MinInt, math.MinInt}, runs[0])
+	assert.Equal([2]int{math.MaxInt, math.MaxInt}, runs[len(runs)-1])
+}
+
+func TestWithinDistance(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	assert.Nil(WithinDistance(s, 0, 10))
+
+	for _, x := range []int{-20, -7, -3, 0, 2, 5, 11, 30} {
+		s.Insert(x)
+	}
+	assert.Equal([]int{0, 2, 5}, WithinDistance(s, 2, 3))
+	assert.Equal([]int{2}, WithinDistance(s, 2, 0))
+	assert.Nil(WithinDistance(s, 1, 0))
+	assert.Nil(WithinDistance(s, 2, -1))
+	assert.Equal([]int{-7, -3, 0}, WithinDistance(s, -4, 4))
+	assert.Equal([]int{-20, -7}, WithinDistance(s, -30, 23))
+	assert.Equal([]int{11, 30}, WithinDistance(s, 25, 14))
+	assert.Equal([]int{-20, -7, -3, 0, 2, 5, 11, 30}, WithinDistance(s, 0, math.MaxInt))
+
+	s.Insert(math.MaxInt)
+	s.Insert(math.MinInt)
+	assert.Equal([]int{math.MaxInt}, WithinDistance(s, math.MaxInt-1, 5))
+	assert.Equal([]int{math.MinInt}, WithinDistance(s, math.MinInt+1, 5))
+}
+
+func TestComplement(t *testing.T) {
+	assert := assert.New(t)
+
+	values := func(s *LLRBSet[int]) []int {
+		var a []int
+		s.Range(func(x int) bool {
+			a = append(a, x)
+			return true
+		})
+		return a
+	}
+
+	s := NewSet[int]()
+	assert.Equal([]int{-2, -1, 0, 1, 2}, values(Complement(s, -2, 2)))
+	assert.Empty(values(Complement(s, 3, 2)))
+
+	for _, x := range []int{-10, 1, 2, 3, 5, 8, 50} {
+		s.Insert(x)
+	}
+	assert.Equal([]int{0, 4, 6, 7, 9, 10}, values(Complement(s, 0, 10)))
+	assert.Equal([]int{4}, values(Complement(s, 1, 5)))
+	assert.Empty(values(Complement(s, 1, 3)))
+	assert.Equal([]int{6, 7}, values(Complement(s, 6, 7)))
+
+	dense := NewSet[int]()
+	for x := 0; x < 100; x++ {
+		if x != 42 {
+			dense.Insert(x)
+		}
+	}
+	c := Complement(dense, 0, 99)
+	assert.Equal([]int{42}, values(c))
+	assert.NoError(c.tr.Validate())
+
+	sparse := NewSet[int]()
+	sparse.Insert(50)
+	c = Complement(sparse, 0, 99)
+	assert.Equal(99, c.Len())
+	assert.False(c.Has(50))
+	assert.NoError(c.tr.Validate())
+
+	const top = math.MaxInt
+	edge := NewSet[int]()
+	edge.Insert(top - 1)
+	assert.Equal([]int{top - 2, top}, values(Complement(edge, top-2, top)))
+	edge.Insert(top)
+	assert.Equal([]int{top - 2}, values(Complement(edge, top-2, top)))
+}
+
+func TestUnionSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	newSet := func(a ...int) *LLRBSet[int] {
+		s := NewSet[int]()
+		for _, x := range a {
+			s.Insert(x)
+		}
+		return s
+	}
+
+	s1 := newSet(1, 4, 7, 10)
+	s2 := newSet(2, 4, 6, 10)
+	s3 := newSet()
+	s4 := newSet(0, 7, 11)
+
+	assert.Equal(
+		[]int{0, 1, 2, 4, 6, 7, 10, 11},
+		slices.Collect(UnionSeq(s1, s2, s3, s4)),
+	)
+	assert.Equal([]int{1, 4, 7, 10}, slices.Collect(UnionSeq(s1, s1)))
+	assert.Empty(slices.Collect(UnionSeq(s3)))
+	assert.Empty(slices.Collect(UnionSeq[int]()))
+
+	var collect []int
+	for x := range UnionSeq(s1, s2) {
+		if x > 4 {
+			break
+		}
+		collect = append(collect, x)
+	}
+	assert.Equal([]int{1, 2, 4}, collect)
+
+	a, b := rnd(1000, 500), rnd(1000, 500)
+	want := newSet(append(slices.Clone(a), b...)...)
+	var all []int
+	want.Range(func(x int) bool {
+		all = append(all, x)
+		return true
+	})
+	assert.Equal(all, slices.Collect(UnionSeq(newSet(a...), newSet(b...))))
+}
+
+func TestMapSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSet[int]()
+	assert.Empty(slices.Collect(MapSeq(s, strconv.Itoa)))
+
+	for _, x := range []int{3, 1, 2, 10} {
+		s.Insert(x)
+	}
+	assert.Equal([]string{"1", "2", "3", "10"}, slices.Collect(MapSeq(s, strconv.Itoa)))
+
+	calls := 0
+	var collect []int
+	for x := range MapSeq(s, func(x int) int {
+		calls++
+		return x * x
+	}) {
+		if x > 4 {
+			break
+		}
+		collect = append(collect, x)
+	}
+	assert.Equal([]int{1, 4}, collect)
+	assert.Equal(3, calls)
+}
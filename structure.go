@@ -0,0 +1,128 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SameStructure reports whether the two trees have exactly the same shape,
+// the same node colors, and equal items at every position according to the
+// compare function of t.
+func (t *LLRBTree[T]) SameStructure(other *LLRBTree[T]) bool {
+	var same func(a, b *node[T]) bool
+	same = func(a, b *node[T]) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.color() == b.color() && t.compare(a.item, b.item) == 0 &&
+			same(a.left, b.left) && same(a.right, b.right)
+	}
+	return t.len == other.len && same(t.root, other.root)
+}
+
+// MarshalStructureJSON encodes the exact structure of the tree as nested JSON
+// arrays. Every node is encoded as [item, red, left, right], where item is
+// encoded with encoding/json, red is true for a red node, and left and right
+// are the encoded subtrees, or null for missing children. An empty tree is
+// encoded as null.
+//
+// Unlike a sorted list of the items, the output keeps the shape and colors of
+// the tree, so UnmarshalStructureJSON can restore an identical snapshot.
+func (t *LLRBTree[T]) MarshalStructureJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	var encode func(h *node[T]) error
+	encode = func(h *node[T]) error {
+		if h == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		item, err := json.Marshal(h.item)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('[')
+		buf.Write(item)
+		if h.color() == _red {
+			buf.WriteString(",true,")
+		} else {
+			buf.WriteString(",false,")
+		}
+		if err := encode(h.left); err != nil {
+			return err
+		}
+		buf.WriteByte(',')
+		if err := encode(h.right); err != nil {
+			return err
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	if err := encode(t.root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalStructureJSON replaces the items of the tree with the ones encoded
+// by MarshalStructureJSON, restoring the encoded shape and colors exactly.
+// The tree keeps its compare function and options. It returns an error,
+// leaving the tree unchanged, if the data is malformed or does not describe
+// a valid LLRB-Tree under the compare function of the tree.
+func (t *LLRBTree[T]) UnmarshalStructureJSON(data []byte) error {
+	// The nodes are built for a separate tree, which is only adopted once
+	// it is known to be valid.
+	decoded := &LLRBTree[T]{compare: t.compare, opts: t.opts, seq: t.seq, token: t.token}
+	var decode func(data json.RawMessage) (*node[T], error)
+	decode = func(data json.RawMessage) (*node[T], error) {
+		if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+			return nil, nil
+		}
+		var fields []json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("llrb: node has %d fields, want 4", len(fields))
+		}
+		var item T
+		if err := json.Unmarshal(fields[0], &item); err != nil {
+			return nil, err
+		}
+		var red bool
+		if err := json.Unmarshal(fields[1], &red); err != nil {
+			return nil, err
+		}
+		h := decoded.newNode(item)
+		h.setColor(red)
+		var err error
+		if h.left, err = decode(fields[2]); err != nil {
+			return nil, err
+		}
+		if h.right, err = decode(fields[3]); err != nil {
+			return nil, err
+		}
+		decoded.update(h)
+		return h, nil
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return errors.New("llrb: empty structure")
+	}
+	root, err := decode(data)
+	if err != nil {
+		return err
+	}
+	decoded.root, decoded.len = root, size(root)
+	if err := decoded.Validate(); err != nil {
+		return err
+	}
+	t.root, t.len, t.seq = decoded.root, decoded.len, decoded.seq
+	t.order, t.orderShared = decoded.order, false
+	return nil
+}
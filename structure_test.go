@@ -0,0 +1,113 @@
+// Copyright 2024 Shaolong Chen. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package llrb
+
+import (
+	"cmp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLRBTree_SameStructure(t *testing.T) {
+	assert := assert.New(t)
+
+	t1, t2 := NewOrdered[int](), NewOrdered[int]()
+	assert.True(t1.SameStructure(t2))
+
+	for _, x := range seq(10) {
+		t1.ReplaceOrInsert(x)
+	}
+	assert.False(t1.SameStructure(t2))
+	assert.True(t1.SameStructure(t1))
+
+	t2.MergeSortedSlice(seq(10))
+	assert.Equal(t1.items(), t2.items())
+	assert.False(t1.SameStructure(t2))
+
+	t3 := FromLevelOrder(cmp.Compare[int], t1.LevelOrder())
+	assert.True(t1.SameStructure(t3))
+	t3.ReplaceOrInsert(11)
+	assert.False(t1.SameStructure(t3))
+}
+
+func TestLLRBTree_StructureJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	data, err := tree.MarshalStructureJSON()
+	assert.NoError(err)
+	assert.Equal("null", string(data))
+
+	for _, x := range []int{1, 2, 3} {
+		tree.ReplaceOrInsert(x)
+	}
+	data, err = tree.MarshalStructureJSON()
+	assert.NoError(err)
+	assert.Equal("[2,false,[1,false,null,null],[3,false,null,null]]", string(data))
+
+	tree.ReplaceOrInsert(0)
+	data, err = tree.MarshalStructureJSON()
+	assert.NoError(err)
+	assert.Equal("[2,false,[1,false,[0,true,null,null],null],[3,false,null,null]]", string(data))
+
+	for _, x := range rnd(1000, 5000) {
+		tree.ReplaceOrInsert(x)
+	}
+	for _, x := range rnd(500, 5000) {
+		tree.Delete(x)
+	}
+	data, err = tree.MarshalStructureJSON()
+	assert.NoError(err)
+
+	decoded := NewOrdered[int](WithHeightTracking())
+	decoded.ReplaceOrInsert(-1)
+	assert.NoError(decoded.UnmarshalStructureJSON(data))
+	assert.NoError(decoded.Validate())
+	assert.True(tree.SameStructure(decoded))
+	assert.Equal(tree.Len(), decoded.Len())
+	assert.Equal(tree.Height(), decoded.Height())
+
+	assert.NoError(decoded.UnmarshalStructureJSON([]byte("null")))
+	assert.Equal(0, decoded.Len())
+
+	words := NewOrdered[string]()
+	for _, w := range strings.Fields("the quick brown fox jumps over the lazy dog") {
+		words.ReplaceOrInsert(w)
+	}
+	data, err = words.MarshalStructureJSON()
+	assert.NoError(err)
+	words2 := NewOrdered[string]()
+	assert.NoError(words2.UnmarshalStructureJSON(data))
+	assert.True(words.SameStructure(words2))
+}
+
+func TestLLRBTree_UnmarshalStructureJSON_invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := NewOrdered[int]()
+	for _, x := range seq(3) {
+		tree.ReplaceOrInsert(x)
+	}
+	want := tree.LevelOrder()
+
+	for _, data := range []string{
+		"",
+		"{}",
+		"[1,false,null]",
+		`["a",false,null,null]`,
+		"[1,1,null,null]",
+		"[1,true,null,null]",
+		"[1,false,null,[2,true,null,null]]",
+		"[2,false,[3,true,null,null],null]",
+		"[2,false,[1,false,null,null],null]",
+		"[1,false,null,null",
+	} {
+		assert.Error(tree.UnmarshalStructureJSON([]byte(data)), data)
+		assert.Equal(want, tree.LevelOrder())
+		assert.Equal(3, tree.Len())
+	}
+}